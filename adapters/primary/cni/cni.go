@@ -0,0 +1,287 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cni implements the CNI ADD/DEL/CHECK commands on top of
+// driver_core, the same business logic docker_libnetwork_plugin drives, so
+// the driver can also be consumed as a (possibly chained, e.g. under Multus)
+// CNI plugin instead of a libnetwork remote driver. Unlike the Docker
+// driver, a CNI plugin is exec'd fresh for every command: CmdAdd/CmdDel/
+// CmdCheck each parse their own NetConf from stdin and wire up their own
+// core, rather than sharing one long-lived ContrailDriverCore.
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest/auth"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hyperv_extension"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/contrail_ipam"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/local_ipam"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hcn"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
+	"github.com/Juniper/contrail-windows-docker-driver/agent"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/driver_core"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Juniper/contrail-windows-docker-driver/core/vrouter"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/current"
+	log "github.com/sirupsen/logrus"
+)
+
+// NetConf is the JSON configuration contrail-cni reads from stdin: the
+// standard CNI network configuration fields, plus everything the driver
+// needs to reach Contrail/vRouter and pick an HNS/HCN backend, mirroring
+// the flags main.go exposes for the Docker driver.
+type NetConf struct {
+	types.NetConf
+
+	// Tenant/Network select the Contrail virtual-network to back the
+	// interface with, same as the "tenant"/"network" docker driver-opts.
+	Tenant  string `json:"tenant"`
+	Network string `json:"network"`
+	// Subnet picks which of the VN's subnets to bind to, by CIDR. Left
+	// empty, the VN's first subnet is used.
+	Subnet string `json:"subnet,omitempty"`
+
+	// ControllerIP/ControllerPort address the Contrail Controller API.
+	ControllerIP   string `json:"controllerIP"`
+	ControllerPort int    `json:"controllerPort"`
+	// AgentURL addresses the local vRouter agent's REST API.
+	AgentURL string `json:"agentURL"`
+	// Adapter is the physical net adapter backing the HNS/HCN switch.
+	Adapter string `json:"adapter"`
+	// VSwitchName is the name of the Transparent virtual switch fronting
+	// Adapter.
+	VSwitchName string `json:"vswitchName"`
+	// NetworkBackend selects the Windows networking API used to back the
+	// endpoint: "hns" (default, the legacy HNS HTTP-over-RPC shim) or "hcn"
+	// (the newer HCN v2 API).
+	NetworkBackend string `json:"networkBackend,omitempty"`
+	// IPAMBackend selects which ports.IPAM implementation allocates the
+	// endpoint's address: "contrail" (default, delegates to the Contrail
+	// controller) or "local" (a bitmap allocator persisted under
+	// ProgramData).
+	IPAMBackend string `json:"ipamBackend,omitempty"`
+	// Keystone carries Contrail authentication credentials, if the
+	// controller requires them.
+	Keystone auth.KeystoneParams `json:"keystone,omitempty"`
+}
+
+// CmdAdd implements the CNI ADD command: it allocates a Contrail
+// instance-IP for the network identified by conf.Tenant/conf.Network and
+// creates the backing HNS/HCN endpoint for args.ContainerID, then prints a
+// current.Result describing it, so a chained plugin (or kubelet) can wire
+// up the container's namespace from it.
+func CmdAdd(args *skel.CmdArgs) error {
+	conf, core, err := parseAndWire(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if _, err := core.CreateNetwork(conf.Name, conf.Tenant, conf.Network,
+		driver_core.CreateNetworkOptions{Subnet: conf.Subnet}); err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	ep, err := core.CreateEndpoint(conf.Name, args.ContainerID, "",
+		driver_core.CreateEndpointOptions{})
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	result, err := endpointResult(ep)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+// CmdDel implements the CNI DEL command: it tears down the HNS/HCN
+// endpoint and releases the Contrail instance-IP allocated for
+// args.ContainerID. It's idempotent, since CNI may retry a DEL that
+// partially succeeded (or ran against a network that's already gone).
+func CmdDel(args *skel.CmdArgs) error {
+	_, core, err := parseAndWire(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	ep, err := core.GetEndpoint(args.ContainerID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if ep == nil {
+		return nil
+	}
+
+	if err := core.DeleteEndpoint(args.ContainerID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+// CmdCheck implements the CNI CHECK command: it verifies the endpoint
+// args.ContainerID refers to still exists.
+func CmdCheck(args *skel.CmdArgs) error {
+	_, core, err := parseAndWire(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	ep, err := core.GetEndpoint(args.ContainerID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if ep == nil {
+		return fmt.Errorf("endpoint %s doesn't exist", args.ContainerID)
+	}
+	return nil
+}
+
+// parseAndWire parses stdin as a NetConf and wires up a ContrailDriverCore
+// against the real Contrail controller, vRouter agent and HNS/HCN backend
+// it describes, the same dependencies main.go wires the Docker driver
+// against.
+func parseAndWire(stdin []byte) (*NetConf, *driver_core.ContrailDriverCore, error) {
+	conf := &NetConf{
+		ControllerIP:   "127.0.0.1",
+		ControllerPort: 8082,
+		AgentURL:       "http://127.0.0.1:9091",
+		Adapter:        "Ethernet0",
+		NetworkBackend: "hns",
+		IPAMBackend:    "contrail",
+	}
+	if err := json.Unmarshal(stdin, conf); err != nil {
+		err = fmt.Errorf("failed to parse network configuration: %v", err)
+		log.Errorln(err)
+		return nil, nil, err
+	}
+	if conf.Tenant == "" || conf.Network == "" {
+		err := fmt.Errorf("tenant and network are required")
+		log.Errorln(err)
+		return nil, nil, err
+	}
+
+	controller, err := controller_rest.NewControllerWithKeystoneAdapter(&conf.Keystone,
+		conf.ControllerIP, conf.ControllerPort)
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, err
+	}
+
+	agentURL, err := url.Parse(conf.AgentURL)
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, err
+	}
+	agentAPI := agent.NewAgentRestAPI(http.DefaultClient, agentURL)
+
+	hypervExtension := hyperv_extension.NewHyperVvRouterForwardingExtension(conf.VSwitchName)
+	vr := vrouter.NewHyperVvRouter(hypervExtension)
+
+	var netRepo ports.LocalContrailNetworkRepository
+	var epRepo ports.EndpointRepository
+	switch conf.NetworkBackend {
+	case "hcn":
+		netRepo = hcn.NewHCNContrailNetworksRepository(common.AdapterName(conf.Adapter), hcn.NetworkingAdapter{})
+		epRepo = hcn.NewHCNEndpointRepository(hcn.NetworkingAdapter{})
+	default:
+		netRepo, err = hns.NewHNSContrailNetworksRepository(common.AdapterName(conf.Adapter), conf.VSwitchName, hns.HNSNetworkingAdapter{})
+		epRepo = hns.NewHNSEndpointRepository(hns.HNSNetworkingAdapter{})
+	}
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, err
+	}
+
+	var ipamBackend ports.IPAM
+	switch conf.IPAMBackend {
+	case "local":
+		ipamBackend, err = local_ipam.NewLocalIPAM(local_ipam.DefaultDBPath())
+	default:
+		ipamBackend = contrail_ipam.NewContrailIPAM()
+	}
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, err
+	}
+
+	core, err := driver_core.NewContrailDriverCore(vr, controller, agentAPI, ipamBackend, netRepo, epRepo)
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, err
+	}
+	return conf, core, nil
+}
+
+// endpointResult translates a just-created ports.LocalEndpoint into the CNI
+// current.Result shape: one interface for the endpoint's vNIC, its
+// Contrail-allocated address and routes, and the DNS configuration
+// Contrail handed out for it, if any.
+func endpointResult(ep *ports.LocalEndpoint) (*current.Result, error) {
+	prefixLen := ep.PrefixLen
+	if prefixLen == 0 {
+		prefixLen = 32
+	}
+	ip, _, err := net.ParseCIDR(fmt.Sprintf("%s/%d", ep.IPAddress, prefixLen))
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance-ip address %q: %v", ep.IPAddress, err)
+	}
+
+	result := &current.Result{
+		Interfaces: []*current.Interface{
+			{Name: ep.ID, Mac: ep.MacAddress, Sandbox: ep.HNSID},
+		},
+		IPs: []*current.IPConfig{
+			{
+				Version:   "4",
+				Address:   net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, 32)},
+				Gateway:   net.ParseIP(ep.Gateway),
+				Interface: current.Int(0),
+			},
+		},
+	}
+
+	for _, route := range ep.Routes {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route destination %q: %v", route.Destination, err)
+		}
+		result.Routes = append(result.Routes, &types.Route{Dst: *dst, GW: net.ParseIP(route.NextHop)})
+	}
+
+	if ep.DNS != nil {
+		result.DNS = types.DNS{
+			Nameservers: ep.DNS.Servers,
+			Domain:      ep.DNS.Suffix,
+			Search:      ep.DNS.Search,
+		}
+	}
+
+	return result, nil
+}
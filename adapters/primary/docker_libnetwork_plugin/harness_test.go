@@ -0,0 +1,498 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker_libnetwork_plugin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/docker_libnetwork_plugin"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hyperv_extension"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/contrail_ipam"
+	netSim "github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/simulator"
+	"github.com/Juniper/contrail-windows-docker-driver/agent"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/driver_core"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Juniper/contrail-windows-docker-driver/core/vrouter"
+	"github.com/docker/go-plugins-helpers/network"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestDriverInProcess runs the in-process Describe blocks (this file only).
+// Unlike TestDriver in plugin_test.go (build tag "integration"), this suite
+// needs neither a running docker daemon nor a real HNS/Contrail deployment,
+// so it runs as part of the normal `go test ./...`.
+func TestDriverInProcess(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Contrail Network Driver in-process test suite")
+}
+
+const (
+	harnessTenantName  = "agatka"
+	harnessNetworkName = "test_net"
+	harnessSubnetCIDR  = "1.2.3.4/24"
+)
+
+// harness drives a DockerPluginServer's CNM handlers directly over HTTP, via
+// httptest.Server, so NetworkDriver behavior can be exercised without a real
+// docker daemon and without going through the named pipe transport. server is
+// the same *DockerPluginServer the httptest.Server wraps, exposed so tests can
+// call a handler in-process when they need to assert on a concrete Go error
+// type: writeResponse flattens every non-maskable error to a bare
+// `{"Err": "..."}` string over HTTP, which h.do can't unwrap back into one.
+type harness struct {
+	httpServer *httptest.Server
+	server     *docker_libnetwork_plugin.DockerPluginServer
+}
+
+func newHarness(core *driver_core.ContrailDriverCore) *harness {
+	d := docker_libnetwork_plugin.NewDockerPluginServer(core)
+	return &harness{httpServer: httptest.NewServer(d.Handler()), server: d}
+}
+
+func (h *harness) Close() {
+	h.httpServer.Close()
+}
+
+// do POSTs req as JSON to path and, on success, decodes the response into
+// resp (which may be nil if the caller doesn't care about the body). Remote
+// driver errors (the `{"Err": "..."}` envelope) are returned as a Go error.
+func (h *harness) do(path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := http.Post(h.httpServer.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	raw, err := func() (json.RawMessage, error) {
+		var m json.RawMessage
+		err := json.NewDecoder(httpResp.Body).Decode(&m)
+		return m, err
+	}()
+	if err != nil {
+		return err
+	}
+
+	var errEnvelope struct{ Err string }
+	if err := json.Unmarshal(raw, &errEnvelope); err != nil {
+		return err
+	}
+	if errEnvelope.Err != "" {
+		return fmt.Errorf(errEnvelope.Err)
+	}
+
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, resp)
+}
+
+var _ = Describe("Contrail Network Driver, driven in-process", func() {
+	var h *harness
+	var contrailController ports.Controller
+
+	BeforeEach(func() {
+		ext := &hyperv_extension.HyperVExtensionSimulator{Enabled: false, Running: true}
+		vr := vrouter.NewHyperVvRouter(ext)
+
+		contrailController = controller_rest.NewFakeControllerAdapter()
+		_, err := contrailController.NewProject(common.DomainName, harnessTenantName)
+		Expect(err).ToNot(HaveOccurred())
+
+		netRepo := &netSim.InMemContrailNetworksRepository{}
+		epRepo := &netSim.InMemEndpointRepository{}
+		serverURL, _ := url.Parse("http://127.0.0.1:9091")
+		a := agent.NewAgentRestAPI(http.DefaultClient, serverURL)
+
+		core, err := driver_core.NewContrailDriverCore(vr, contrailController, a,
+			contrail_ipam.NewContrailIPAM(), netRepo, epRepo)
+		Expect(err).ToNot(HaveOccurred())
+
+		h = newHarness(core)
+	})
+
+	AfterEach(func() {
+		h.Close()
+	})
+
+	It("creates a network and an endpoint without a real docker daemon", func() {
+		_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName, harnessNetworkName,
+			harnessSubnetCIDR)
+		Expect(err).ToNot(HaveOccurred())
+
+		createNetReq := &network.CreateNetworkRequest{
+			NetworkID: "test-net-id",
+			Options: map[string]interface{}{
+				"com.docker.network.generic": map[string]interface{}{
+					"tenant":  harnessTenantName,
+					"network": harnessNetworkName,
+				},
+			},
+		}
+		Expect(h.do("/NetworkDriver.CreateNetwork", createNetReq, nil)).To(Succeed())
+
+		createEpReq := &network.CreateEndpointRequest{
+			NetworkID:  "test-net-id",
+			EndpointID: "test-ep-id",
+		}
+		var createEpResp network.CreateEndpointResponse
+		Expect(h.do("/NetworkDriver.CreateEndpoint", createEpReq, &createEpResp)).To(Succeed())
+		Expect(createEpResp.Interface.Address).ToNot(BeEmpty())
+
+		Expect(h.do("/NetworkDriver.DeleteEndpoint",
+			&network.DeleteEndpointRequest{NetworkID: "test-net-id", EndpointID: "test-ep-id"},
+			nil)).To(Succeed())
+		Expect(h.do("/NetworkDriver.DeleteNetwork",
+			&network.DeleteNetworkRequest{NetworkID: "test-net-id"}, nil)).To(Succeed())
+	})
+})
+
+// newGlobalScopeHarness builds a harness for hostname sharing contrailController
+// and netRepo with every other harness built against the same arguments (as
+// real GlobalScope hosts share the one Contrail virtual-network), but its
+// own epRepo, since an endpoint's HNS vNIC is only ever local to the host
+// that created it.
+func newGlobalScopeHarness(contrailController ports.Controller,
+	netRepo ports.LocalContrailNetworkRepository, hostname string) *harness {
+
+	ext := &hyperv_extension.HyperVExtensionSimulator{Enabled: false, Running: true}
+	vr := vrouter.NewHyperVvRouter(ext)
+	epRepo := &netSim.InMemEndpointRepository{}
+	serverURL, _ := url.Parse("http://127.0.0.1:9091")
+	a := agent.NewAgentRestAPI(http.DefaultClient, serverURL)
+
+	core, err := driver_core.NewContrailDriverCore(vr, contrailController, a,
+		contrail_ipam.NewContrailIPAM(), netRepo, epRepo)
+	Expect(err).ToNot(HaveOccurred())
+	core = core.WithScope(driver_core.GlobalScope).WithHostname(hostname)
+
+	return newHarness(core)
+}
+
+// This Describe ports plugin_test.go's (build-tag "integration") pending
+// "on CreateEndpoint request" and "on DeleteEndpoint request" groups onto the
+// in-process harness, so the scenarios that don't inherently need a real
+// docker daemon or real HNS run as part of normal `go test ./...`. Assertions
+// that depended on inspecting a real container (docker.ContainerInspect) or a
+// real HNS endpoint (hns.GetHNSEndpoint) are rewritten against the harness's
+// HTTP responses and the in-memory netRepo/epRepo fakes instead.
+var _ = Describe("Contrail Network Driver, CreateEndpoint and DeleteEndpoint requests", func() {
+	var h *harness
+	var contrailController ports.Controller
+	var netRepo ports.LocalContrailNetworkRepository
+
+	BeforeEach(func() {
+		ext := &hyperv_extension.HyperVExtensionSimulator{Enabled: false, Running: true}
+		vr := vrouter.NewHyperVvRouter(ext)
+
+		contrailController = controller_rest.NewFakeControllerAdapter()
+		_, err := contrailController.NewProject(common.DomainName, harnessTenantName)
+		Expect(err).ToNot(HaveOccurred())
+
+		netRepo = &netSim.InMemContrailNetworksRepository{}
+		epRepo := &netSim.InMemEndpointRepository{}
+		serverURL, _ := url.Parse("http://127.0.0.1:9091")
+		a := agent.NewAgentRestAPI(http.DefaultClient, serverURL)
+
+		core, err := driver_core.NewContrailDriverCore(vr, contrailController, a,
+			contrail_ipam.NewContrailIPAM(), netRepo, epRepo)
+		Expect(err).ToNot(HaveOccurred())
+
+		h = newHarness(core)
+	})
+
+	AfterEach(func() {
+		h.Close()
+	})
+
+	Context("on CreateEndpoint request", func() {
+		Context("Contrail and docker networks exist", func() {
+			BeforeEach(func() {
+				_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName,
+					harnessNetworkName, harnessSubnetCIDR)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(h.do("/NetworkDriver.CreateNetwork", &network.CreateNetworkRequest{
+					NetworkID: "test-net-id",
+					Options: map[string]interface{}{
+						"com.docker.network.generic": map[string]interface{}{
+							"tenant":  harnessTenantName,
+							"network": harnessNetworkName,
+						},
+					},
+				}, nil)).To(Succeed())
+			})
+
+			It("configures the endpoint with an address and MAC address", func() {
+				var resp network.CreateEndpointResponse
+				Expect(h.do("/NetworkDriver.CreateEndpoint", &network.CreateEndpointRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "test-ep-id",
+				}, &resp)).To(Succeed())
+
+				Expect(resp.Interface.Address).ToNot(BeEmpty())
+				Expect(resp.Interface.MacAddress).ToNot(BeEmpty())
+			})
+		})
+
+		Context("Contrail and docker networks exist, the network repository lost track of it", func() {
+			// e.g. HNS was hard-reset while docker wasn't.
+			BeforeEach(func() {
+				_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName,
+					harnessNetworkName, harnessSubnetCIDR)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(h.do("/NetworkDriver.CreateNetwork", &network.CreateNetworkRequest{
+					NetworkID: "test-net-id",
+					Options: map[string]interface{}{
+						"com.docker.network.generic": map[string]interface{}{
+							"tenant":  harnessTenantName,
+							"network": harnessNetworkName,
+						},
+					},
+				}, nil)).To(Succeed())
+
+				Expect(netRepo.DeleteNetwork("test-net-id")).To(Succeed())
+			})
+
+			It("responds with err", func() {
+				err := h.do("/NetworkDriver.CreateEndpoint", &network.CreateEndpointRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "test-ep-id",
+				}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("Contrail network exists, docker network doesn't", func() {
+			BeforeEach(func() {
+				_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName,
+					harnessNetworkName, harnessSubnetCIDR)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("responds with err", func() {
+				err := h.do("/NetworkDriver.CreateEndpoint", &network.CreateEndpointRequest{
+					NetworkID:  "nonexistent-net-id",
+					EndpointID: "test-ep-id",
+				}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("on EndpointOperInfo request", func() {
+		BeforeEach(func() {
+			_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName,
+				harnessNetworkName, harnessSubnetCIDR)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(h.do("/NetworkDriver.CreateNetwork", &network.CreateNetworkRequest{
+				NetworkID: "test-net-id",
+				Options: map[string]interface{}{
+					"com.docker.network.generic": map[string]interface{}{
+						"tenant":  harnessTenantName,
+						"network": harnessNetworkName,
+					},
+				},
+			}, nil)).To(Succeed())
+
+			Expect(h.do("/NetworkDriver.CreateEndpoint", &network.CreateEndpointRequest{
+				NetworkID:  "test-net-id",
+				EndpointID: "test-ep-id",
+			}, nil)).To(Succeed())
+		})
+
+		Context("queried endpoint exists", func() {
+			It("responds with the endpoint's runtime data", func() {
+				var resp network.InfoResponse
+				Expect(h.do("/NetworkDriver.EndpointOperInfo", &network.InfoRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "test-ep-id",
+				}, &resp)).To(Succeed())
+
+				Expect(resp.Value).To(HaveKey("contrail.instance_ip"))
+				Expect(resp.Value).To(HaveKey("contrail.mac"))
+			})
+		})
+
+		Context("queried endpoint doesn't exist", func() {
+			It("responds with err", func() {
+				err := h.do("/NetworkDriver.EndpointOperInfo", &network.InfoRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "nonexistent-ep-id",
+				}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("on DeleteEndpoint request", func() {
+		BeforeEach(func() {
+			_, err := contrailController.CreateNetworkWithSubnet(harnessTenantName,
+				harnessNetworkName, harnessSubnetCIDR)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(h.do("/NetworkDriver.CreateNetwork", &network.CreateNetworkRequest{
+				NetworkID: "test-net-id",
+				Options: map[string]interface{}{
+					"com.docker.network.generic": map[string]interface{}{
+						"tenant":  harnessTenantName,
+						"network": harnessNetworkName,
+					},
+				},
+			}, nil)).To(Succeed())
+
+			Expect(h.do("/NetworkDriver.CreateEndpoint", &network.CreateEndpointRequest{
+				NetworkID:  "test-net-id",
+				EndpointID: "test-ep-id",
+			}, nil)).To(Succeed())
+		})
+
+		Context("happy case: endpoint exists", func() {
+			It("removes the endpoint", func() {
+				Expect(h.do("/NetworkDriver.DeleteEndpoint", &network.DeleteEndpointRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "test-ep-id",
+				}, nil)).To(Succeed())
+
+				err := h.do("/NetworkDriver.EndpointOperInfo", &network.InfoRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "test-ep-id",
+				}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("endpoint doesn't exist", func() {
+			It("responds with err", func() {
+				err := h.do("/NetworkDriver.DeleteEndpoint", &network.DeleteEndpointRequest{
+					NetworkID:  "test-net-id",
+					EndpointID: "nonexistent-ep-id",
+				}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	// h.do round-trips every handler over real HTTP, and writeResponse
+	// flattens any non-maskable error down to a bare `{"Err": "..."}` string
+	// before it hits the wire, so it can't tell an EndpointNotFoundError from
+	// any other failure. Call h.server's handlers directly instead, so the
+	// concrete Go error type survives to be asserted on.
+	Context("not-found errors, asserted on the concrete Go type", func() {
+		It("Join responds with an EndpointNotFoundError", func() {
+			_, err := h.server.Join(&network.JoinRequest{
+				NetworkID:  "test-net-id",
+				EndpointID: "nonexistent-ep-id",
+			})
+			Expect(err).To(BeAssignableToTypeOf(&driver_core.EndpointNotFoundError{}))
+		})
+
+		It("EndpointOperInfo responds with an EndpointNotFoundError", func() {
+			_, err := h.server.EndpointOperInfo(&network.InfoRequest{
+				NetworkID:  "test-net-id",
+				EndpointID: "nonexistent-ep-id",
+			})
+			Expect(err).To(BeAssignableToTypeOf(&driver_core.EndpointNotFoundError{}))
+		})
+
+		It("DeleteEndpoint responds with an EndpointNotFoundError", func() {
+			err := h.server.DeleteEndpoint(&network.DeleteEndpointRequest{
+				NetworkID:  "test-net-id",
+				EndpointID: "nonexistent-ep-id",
+			})
+			Expect(err).To(BeAssignableToTypeOf(&driver_core.EndpointNotFoundError{}))
+		})
+	})
+})
+
+var _ = Describe("Contrail Network Driver, GlobalScope across hosts", func() {
+	var contrailController ports.Controller
+	var netRepo ports.LocalContrailNetworkRepository
+	var hostA, hostB *harness
+
+	BeforeEach(func() {
+		contrailController = controller_rest.NewFakeControllerAdapter()
+		_, err := contrailController.NewProject(common.DomainName, harnessTenantName)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = contrailController.CreateNetworkWithSubnet(harnessTenantName, harnessNetworkName,
+			harnessSubnetCIDR)
+		Expect(err).ToNot(HaveOccurred())
+
+		netRepo = &netSim.InMemContrailNetworksRepository{}
+		hostA = newGlobalScopeHarness(contrailController, netRepo, "host-a")
+		hostB = newGlobalScopeHarness(contrailController, netRepo, "host-b")
+	})
+
+	AfterEach(func() {
+		hostA.Close()
+		hostB.Close()
+	})
+
+	It("lets a second host discover, but not Join/Leave, an endpoint created on the first", func() {
+		createNetReq := &network.CreateNetworkRequest{
+			NetworkID: "global-net-id",
+			Options: map[string]interface{}{
+				"com.docker.network.generic": map[string]interface{}{
+					"tenant":  harnessTenantName,
+					"network": harnessNetworkName,
+				},
+			},
+		}
+		Expect(hostA.do("/NetworkDriver.CreateNetwork", createNetReq, nil)).To(Succeed())
+		// GlobalScope: the Contrail VN is shared, so hostB sees the same
+		// network without creating it itself.
+		Expect(hostB.do("/NetworkDriver.CreateNetwork", createNetReq, nil)).To(Succeed())
+
+		createEpReq := &network.CreateEndpointRequest{
+			NetworkID:  "global-net-id",
+			EndpointID: "global-ep-id",
+		}
+		var createEpResp network.CreateEndpointResponse
+		Expect(hostA.do("/NetworkDriver.CreateEndpoint", createEpReq, &createEpResp)).To(Succeed())
+
+		By("hostB's EndpointOperInfo reports the endpoint as owned by hostA")
+		var infoResp network.InfoResponse
+		Expect(hostB.do("/NetworkDriver.EndpointOperInfo",
+			&network.InfoRequest{NetworkID: "global-net-id", EndpointID: "global-ep-id"},
+			&infoResp)).To(Succeed())
+		Expect(infoResp.Value).To(HaveKeyWithValue("com.docker.network.endpoint.host", "host-a"))
+
+		By("hostB can't Join an endpoint that lives on hostA")
+		err := hostB.do("/NetworkDriver.Join",
+			&network.JoinRequest{NetworkID: "global-net-id", EndpointID: "global-ep-id"}, nil)
+		Expect(err).To(HaveOccurred())
+
+		By("hostA can still Join its own endpoint")
+		Expect(hostA.do("/NetworkDriver.Join",
+			&network.JoinRequest{NetworkID: "global-net-id", EndpointID: "global-ep-id"},
+			nil)).To(Succeed())
+	})
+})
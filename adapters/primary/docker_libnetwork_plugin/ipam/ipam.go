@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipam serves the libnetwork remote IpamDriver API, delegating to
+// core/ipam_core. It is meant to be registered on the same named pipe as the
+// docker_libnetwork_plugin NetworkDriver, via Server.RegisterHandlers.
+package ipam
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Juniper/contrail-windows-docker-driver/core/ipam_core"
+	"github.com/Juniper/contrail-windows-docker-driver/telemetry"
+	"github.com/docker/go-plugins-helpers/ipam"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server implements the libnetwork IpamDriver remote API.
+type Server struct {
+	core *ipam_core.IpamCore
+}
+
+// NewServer creates an ipam.Server backed by core.
+func NewServer(core *ipam_core.IpamCore) *Server {
+	return &Server{core: core}
+}
+
+// RegisterHandlers wires this server's routes into mux, so it can be served
+// alongside the NetworkDriver on the same named pipe.
+func (s *Server) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/IpamDriver.GetCapabilities", s.handle(func() (interface{}, error) {
+		return s.GetCapabilities()
+	}))
+	mux.HandleFunc("/IpamDriver.GetDefaultAddressSpaces", s.handle(func() (interface{}, error) {
+		return s.GetDefaultAddressSpaces()
+	}))
+	mux.HandleFunc("/IpamDriver.RequestPool", s.handleReq(&ipam.RequestPoolRequest{},
+		func(req interface{}) (interface{}, error) {
+			return s.RequestPool(req.(*ipam.RequestPoolRequest))
+		}))
+	mux.HandleFunc("/IpamDriver.ReleasePool", s.handleReq(&ipam.ReleasePoolRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, s.ReleasePool(req.(*ipam.ReleasePoolRequest))
+		}))
+	mux.HandleFunc("/IpamDriver.RequestAddress", s.handleReq(&ipam.RequestAddressRequest{},
+		func(req interface{}) (interface{}, error) {
+			return s.RequestAddress(req.(*ipam.RequestAddressRequest))
+		}))
+	mux.HandleFunc("/IpamDriver.ReleaseAddress", s.handleReq(&ipam.ReleaseAddressRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, s.ReleaseAddress(req.(*ipam.ReleaseAddressRequest))
+		}))
+}
+
+// handle and handleReq wrap every IpamDriver handler in an OpenTelemetry
+// span, for the same end-to-end tracing docker_libnetwork_plugin.handle/
+// handleReq give NetworkDriver requests.
+func (s *Server) handle(fn func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := telemetry.Tracer().Start(r.Context(), r.URL.Path)
+		defer span.End()
+
+		resp, err := fn()
+		writeResponse(w, resp, err)
+	}
+}
+
+func (s *Server) handleReq(reqTemplate interface{},
+	fn func(interface{}) (interface{}, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := telemetry.Tracer().Start(r.Context(), r.URL.Path)
+		defer span.End()
+
+		req := newLike(reqTemplate)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+		resp, err := fn(req)
+		writeResponse(w, resp, err)
+	}
+}
+
+func newLike(template interface{}) interface{} {
+	switch template.(type) {
+	case *ipam.RequestPoolRequest:
+		return &ipam.RequestPoolRequest{}
+	case *ipam.ReleasePoolRequest:
+		return &ipam.ReleasePoolRequest{}
+	case *ipam.RequestAddressRequest:
+		return &ipam.RequestAddressRequest{}
+	case *ipam.ReleaseAddressRequest:
+		return &ipam.ReleaseAddressRequest{}
+	default:
+		return template
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		log.Errorln(err)
+		writeJSON(w, struct{ Err string }{Err: err.Error()})
+		return
+	}
+	if resp == nil {
+		resp = struct{}{}
+	}
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1.1+json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorln(err)
+	}
+}
+
+// GetCapabilities tells libnetwork we don't need it to pre-request an
+// address before Join (the default).
+func (s *Server) GetCapabilities() (*ipam.CapabilitiesResponse, error) {
+	return &ipam.CapabilitiesResponse{}, nil
+}
+
+// GetDefaultAddressSpaces is required by the IpamDriver contract, but this
+// driver only allocates pools explicitly via `--ipam-opt`, so it returns
+// empty address spaces.
+func (s *Server) GetDefaultAddressSpaces() (*ipam.AddressSpacesResponse, error) {
+	return &ipam.AddressSpacesResponse{}, nil
+}
+
+func (s *Server) RequestPool(r *ipam.RequestPoolRequest) (*ipam.RequestPoolResponse, error) {
+	poolID, cidr, gateway, err := s.core.RequestPool(
+		r.Options["tenant"], r.Options["network"], r.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipam.RequestPoolResponse{
+		PoolID: poolID,
+		Pool:   cidr,
+		Data:   map[string]string{"com.docker.network.gateway": gateway},
+	}, nil
+}
+
+func (s *Server) ReleasePool(r *ipam.ReleasePoolRequest) error {
+	return s.core.ReleasePool(r.PoolID)
+}
+
+func (s *Server) RequestAddress(
+	r *ipam.RequestAddressRequest) (*ipam.RequestAddressResponse, error) {
+
+	address, err := s.core.RequestAddress(r.PoolID, r.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipam.RequestAddressResponse{Address: address}, nil
+}
+
+func (s *Server) ReleaseAddress(r *ipam.ReleaseAddressRequest) error {
+	return s.core.ReleaseAddress(r.PoolID, r.Address)
+}
@@ -0,0 +1,719 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package docker_libnetwork_plugin serves the libnetwork remote NetworkDriver
+// (and, alongside it, the IpamDriver in the ipam subpackage) CNM API over a
+// Windows named pipe, translating requests into driver_core calls.
+package docker_libnetwork_plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/driver_core"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Juniper/contrail-windows-docker-driver/telemetry"
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-plugins-helpers/network"
+	log "github.com/sirupsen/logrus"
+)
+
+const activatePath = "/Plugin.Activate"
+
+// DockerPluginServer serves the libnetwork NetworkDriver remote API over a
+// named pipe.
+type DockerPluginServer struct {
+	core      *driver_core.ContrailDriverCore
+	mux       *http.ServeMux
+	listener  net.Listener
+	PipeAddr  string
+	IsServing bool
+
+	// Implements is advertised in the /Plugin.Activate response. It defaults
+	// to just "NetworkDriver"; WithIpam appends "IpamDriver" to it when both
+	// drivers are served on the same pipe.
+	Implements []string
+}
+
+// NewDockerPluginServer creates a DockerPluginServer backed by core.
+func NewDockerPluginServer(core *driver_core.ContrailDriverCore) *DockerPluginServer {
+	d := &DockerPluginServer{
+		core:       core,
+		PipeAddr:   `\\.\pipe\` + common.DriverName,
+		Implements: []string{"NetworkDriver"},
+	}
+	d.mux = http.NewServeMux()
+	d.registerHandlers()
+	return d
+}
+
+func (d *DockerPluginServer) registerHandlers() {
+	d.mux.HandleFunc(activatePath, d.handleActivate)
+	d.mux.HandleFunc("/NetworkDriver.GetCapabilities", d.handle(func() (interface{}, error) {
+		return d.GetCapabilities()
+	}))
+	d.mux.HandleFunc("/NetworkDriver.DiscoverNew", d.handleReq(&network.DiscoveryNotification{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.DiscoverNew(req.(*network.DiscoveryNotification))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.DiscoverDelete", d.handleReq(&network.DiscoveryNotification{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.DiscoverDelete(req.(*network.DiscoveryNotification))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.CreateNetwork", d.handleReq(&network.CreateNetworkRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.CreateNetwork(req.(*network.CreateNetworkRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.AllocateNetwork", d.handleReq(&network.AllocateNetworkRequest{},
+		func(req interface{}) (interface{}, error) {
+			return d.AllocateNetwork(req.(*network.AllocateNetworkRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.DeleteNetwork", d.handleReq(&network.DeleteNetworkRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.DeleteNetwork(req.(*network.DeleteNetworkRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.FreeNetwork", d.handleReq(&network.FreeNetworkRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.FreeNetwork(req.(*network.FreeNetworkRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.CreateEndpoint", d.handleReq(&network.CreateEndpointRequest{},
+		func(req interface{}) (interface{}, error) {
+			return d.CreateEndpoint(req.(*network.CreateEndpointRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.DeleteEndpoint", d.handleReq(&network.DeleteEndpointRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.DeleteEndpoint(req.(*network.DeleteEndpointRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.EndpointOperInfo", d.handleReq(&network.InfoRequest{},
+		func(req interface{}) (interface{}, error) {
+			return d.EndpointOperInfo(req.(*network.InfoRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.Join", d.handleReq(&network.JoinRequest{},
+		func(req interface{}) (interface{}, error) {
+			return d.Join(req.(*network.JoinRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.Leave", d.handleReq(&network.LeaveRequest{},
+		func(req interface{}) (interface{}, error) {
+			return struct{}{}, d.Leave(req.(*network.LeaveRequest))
+		}))
+	d.mux.HandleFunc("/NetworkDriver.ProgramExternalConnectivity",
+		d.handleReq(&network.ProgramExternalConnectivityRequest{},
+			func(req interface{}) (interface{}, error) {
+				return struct{}{}, d.ProgramExternalConnectivity(
+					req.(*network.ProgramExternalConnectivityRequest))
+			}))
+	d.mux.HandleFunc("/NetworkDriver.RevokeExternalConnectivity",
+		d.handleReq(&network.RevokeExternalConnectivityRequest{},
+			func(req interface{}) (interface{}, error) {
+				return struct{}{}, d.RevokeExternalConnectivity(
+					req.(*network.RevokeExternalConnectivityRequest))
+			}))
+}
+
+// Handler returns the http.Handler serving the CNM API, so callers that
+// don't want to talk over a named pipe (most notably in-process tests) can
+// drive it directly, e.g. via httptest.Server.
+func (d *DockerPluginServer) Handler() http.Handler {
+	return d.mux
+}
+
+func (d *DockerPluginServer) handleActivate(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Implements []string
+	}{Implements: d.Implements})
+}
+
+// handle wraps a no-argument handler fn, writing its result (or error) as the
+// remote-driver JSON response, inside an OpenTelemetry span so a slow
+// CreateNetwork/CreateEndpoint can be traced through driver_core and on into
+// controller_rest/agent/hns.
+func (d *DockerPluginServer) handle(fn func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := telemetry.Tracer().Start(r.Context(), r.URL.Path)
+		defer span.End()
+
+		resp, err := fn()
+		writeResponse(w, resp, err)
+	}
+}
+
+// handleReq decodes the request body into a fresh copy of reqTemplate,
+// passes it to fn, and writes fn's result (or error) as the response, inside
+// an OpenTelemetry span (see handle).
+func (d *DockerPluginServer) handleReq(reqTemplate interface{},
+	fn func(interface{}) (interface{}, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := telemetry.Tracer().Start(r.Context(), r.URL.Path)
+		defer span.End()
+
+		req := newLike(reqTemplate)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+		resp, err := fn(req)
+		writeResponse(w, resp, err)
+	}
+}
+
+// maskableError is satisfied by driver_core.AgentError (VRouterError doesn't
+// implement it) for failures libnetwork would rather see logged than fail
+// the whole CNM request on, e.g. a DeletePort failing during teardown of an
+// endpoint that's going away regardless.
+type maskableError interface {
+	Maskable() bool
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		if maskable, ok := err.(maskableError); ok && maskable.Maskable() {
+			log.Warnln("masking error:", err)
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Errorln(err)
+		writeJSON(w, struct{ Err string }{Err: err.Error()})
+		return
+	}
+	if resp == nil {
+		resp = struct{}{}
+	}
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1.1+json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorln(err)
+	}
+}
+
+// IpamRegistrar is satisfied by ipam.Server. It lets callers serve the
+// IpamDriver API on the same named pipe as this NetworkDriver, so that a
+// single plugin binary can advertise both.
+type IpamRegistrar interface {
+	RegisterHandlers(mux *http.ServeMux)
+}
+
+// WithIpam registers ipamSrv's handlers on this server's mux and advertises
+// "IpamDriver" alongside "NetworkDriver" in the /Plugin.Activate response.
+func (d *DockerPluginServer) WithIpam(ipamSrv IpamRegistrar) *DockerPluginServer {
+	ipamSrv.RegisterHandlers(d.mux)
+	d.Implements = append(d.Implements, "IpamDriver")
+	return d
+}
+
+// StartServing starts listening for libnetwork requests on the driver's
+// named pipe and writes the plugin spec file so dockerd discovers us.
+func (d *DockerPluginServer) StartServing() error {
+	listener, err := sockets.NewNpipeListener(d.PipeAddr)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	d.listener = listener
+
+	go func() {
+		if err := http.Serve(d.listener, d.mux); err != nil && d.IsServing {
+			log.Errorln(err)
+		}
+	}()
+
+	d.IsServing = true
+	return nil
+}
+
+// StopServing stops the named pipe listener.
+func (d *DockerPluginServer) StopServing() error {
+	d.IsServing = false
+	if d.listener == nil {
+		return nil
+	}
+	return d.listener.Close()
+}
+
+func newLike(template interface{}) interface{} {
+	switch template.(type) {
+	case *network.CreateNetworkRequest:
+		return &network.CreateNetworkRequest{}
+	case *network.AllocateNetworkRequest:
+		return &network.AllocateNetworkRequest{}
+	case *network.DeleteNetworkRequest:
+		return &network.DeleteNetworkRequest{}
+	case *network.FreeNetworkRequest:
+		return &network.FreeNetworkRequest{}
+	case *network.CreateEndpointRequest:
+		return &network.CreateEndpointRequest{}
+	case *network.DeleteEndpointRequest:
+		return &network.DeleteEndpointRequest{}
+	case *network.InfoRequest:
+		return &network.InfoRequest{}
+	case *network.JoinRequest:
+		return &network.JoinRequest{}
+	case *network.LeaveRequest:
+		return &network.LeaveRequest{}
+	case *network.DiscoveryNotification:
+		return &network.DiscoveryNotification{}
+	case *network.ProgramExternalConnectivityRequest:
+		return &network.ProgramExternalConnectivityRequest{}
+	case *network.RevokeExternalConnectivityRequest:
+		return &network.RevokeExternalConnectivityRequest{}
+	default:
+		panic(fmt.Sprintf("docker_libnetwork_plugin: no template for %T", template))
+	}
+}
+
+// GetCapabilities tells libnetwork the scope this driver's networks were
+// configured with (see driver_core.Scope): "local" by default, or "global"
+// for Swarm-wide Contrail virtual-networks.
+func (d *DockerPluginServer) GetCapabilities() (*network.CapabilitiesResponse, error) {
+	return &network.CapabilitiesResponse{Scope: string(d.core.Scope())}, nil
+}
+
+func (d *DockerPluginServer) DiscoverNew(r *network.DiscoveryNotification) error {
+	return nil
+}
+
+func (d *DockerPluginServer) DiscoverDelete(r *network.DiscoveryNotification) error {
+	return nil
+}
+
+func optString(opts map[string]interface{}, key string) string {
+	generic, ok := opts["com.docker.network.generic"]
+	if !ok {
+		return ""
+	}
+	genericMap, ok := generic.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	val, ok := genericMap[key]
+	if !ok {
+		return ""
+	}
+	s, _ := val.(string)
+	return s
+}
+
+// labelPrefix namespaces arbitrary user-supplied network labels
+// (`docker network create --label k=v`) inside the generic options map.
+const labelPrefix = "com.docker.network.label."
+
+func networkLabels(opts map[string]interface{}) map[string]string {
+	generic, ok := opts["com.docker.network.generic"]
+	if !ok {
+		return nil
+	}
+	genericMap, ok := generic.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	labels := map[string]string{}
+	for key, val := range genericMap {
+		if !strings.HasPrefix(key, labelPrefix) {
+			continue
+		}
+		if s, ok := val.(string); ok {
+			labels[strings.TrimPrefix(key, labelPrefix)] = s
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// routeOption mirrors the shape docker sends under the "com.docker.network.routes"
+// option: one entry per `--route destination=via` network driver-opt.
+type routeOption struct {
+	Destination string
+	NextHop     string
+}
+
+func routeOptions(opts map[string]interface{}) ([]ports.RouteOption, error) {
+	raw, ok := opts["com.docker.network.routes"]
+	if !ok {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var routes []routeOption
+	if err := json.Unmarshal(encoded, &routes); err != nil {
+		return nil, err
+	}
+
+	result := make([]ports.RouteOption, 0, len(routes))
+	for _, r := range routes {
+		result = append(result, ports.RouteOption{Destination: r.Destination, NextHop: r.NextHop})
+	}
+	return result, nil
+}
+
+// optUint parses a generic option as an unsigned integer, returning 0 if the
+// option is absent, empty, or not a valid uint.
+func optUint(opts map[string]interface{}, key string) uint {
+	val, err := strconv.ParseUint(optString(opts, key), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(val)
+}
+
+// macPoolOptions reads the "mac_pools" entry of the
+// "com.docker.network.generic" option: a list of {Start, End} MAC ranges,
+// one per pool.
+func macPoolOptions(opts map[string]interface{}) ([]ports.MacPoolRange, error) {
+	raw, ok := opts["com.docker.network.generic"]
+	if !ok {
+		return nil, nil
+	}
+	genericMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	val, ok := genericMap["mac_pools"]
+	if !ok {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var pools []ports.MacPoolRange
+	if err := json.Unmarshal(encoded, &pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// outboundNATOptions builds an OutboundNATOptions from the
+// "outbound_nat_exceptions" generic option (a comma-separated list of CIDRs),
+// or nil if the "enable_outbound_nat" generic option isn't set to "true".
+func outboundNATOptions(opts map[string]interface{}) *ports.OutboundNATOptions {
+	if optString(opts, "enable_outbound_nat") != "true" {
+		return nil
+	}
+	result := &ports.OutboundNATOptions{}
+	if exceptions := optString(opts, "outbound_nat_exceptions"); exceptions != "" {
+		result.Exceptions = strings.Split(exceptions, ",")
+	}
+	return result
+}
+
+// qosOptions builds a QosOptions from the "qos_max_bandwidth" (bytes/sec) and
+// "qos_priority" generic options, or nil if neither is set.
+func qosOptions(opts map[string]interface{}) *ports.QosOptions {
+	maxBandwidth := optString(opts, "qos_max_bandwidth")
+	priority := optString(opts, "qos_priority")
+	if maxBandwidth == "" && priority == "" {
+		return nil
+	}
+	result := &ports.QosOptions{}
+	if maxBandwidth != "" {
+		result.MaxBandwidthBytes, _ = strconv.ParseUint(maxBandwidth, 10, 64)
+	}
+	if priority != "" {
+		p, _ := strconv.ParseUint(priority, 10, 8)
+		result.Priority = uint8(p)
+	}
+	return result
+}
+
+// dnsOptions builds a DNSOptions from the "dns_servers" and "dns_search"
+// (both comma-separated) and "dns_suffix" generic options, or nil if none of
+// them are set.
+func dnsOptions(opts map[string]interface{}) *ports.DNSOptions {
+	servers := optString(opts, "dns_servers")
+	suffix := optString(opts, "dns_suffix")
+	search := optString(opts, "dns_search")
+	if servers == "" && suffix == "" && search == "" {
+		return nil
+	}
+	result := &ports.DNSOptions{Suffix: suffix}
+	if servers != "" {
+		result.Servers = strings.Split(servers, ",")
+	}
+	if search != "" {
+		result.Search = strings.Split(search, ",")
+	}
+	return result
+}
+
+// excludeAddresses parses the "exclude_addresses" generic option: a
+// comma-separated list of individual addresses or "<start>-<end>" ranges to
+// withhold from local IP allocation, honored only by the "local" IPAM
+// backend.
+func excludeAddresses(opts map[string]interface{}) []string {
+	exclude := optString(opts, "exclude_addresses")
+	if exclude == "" {
+		return nil
+	}
+	return strings.Split(exclude, ",")
+}
+
+func auxAddresses(ipv4Data []*network.IPAMData) map[string]string {
+	for _, data := range ipv4Data {
+		if len(data.AuxAddresses) > 0 {
+			return data.AuxAddresses
+		}
+	}
+	return nil
+}
+
+func (d *DockerPluginServer) CreateNetwork(r *network.CreateNetworkRequest) error {
+	tenant := optString(r.Options, "tenant")
+	contrailNetwork := optString(r.Options, "network")
+
+	routes, err := routeOptions(r.Options)
+	if err != nil {
+		return err
+	}
+
+	macPools, err := macPoolOptions(r.Options)
+	if err != nil {
+		return err
+	}
+
+	opts := driver_core.CreateNetworkOptions{
+		Subnet:       optString(r.Options, "subnet"),
+		Routes:       routes,
+		AuxAddresses: auxAddresses(r.IPv4Data),
+		Labels:       networkLabels(r.Options),
+		NetworkMode:  optString(r.Options, "network_mode"),
+		VLAN:         optUint(r.Options, "vlan"),
+		VSID:         optUint(r.Options, "vsid"),
+		MacPools:     macPools,
+		OutboundNAT:  outboundNATOptions(r.Options),
+		IPRange:      optString(r.Options, "ip_range"),
+		Exclude:      excludeAddresses(r.Options),
+	}
+
+	_, err = d.core.CreateNetwork(r.NetworkID, tenant, contrailNetwork, opts)
+	return err
+}
+
+func (d *DockerPluginServer) AllocateNetwork(
+	r *network.AllocateNetworkRequest) (*network.AllocateNetworkResponse, error) {
+	return nil, fmt.Errorf("AllocateNetwork isn't implemented: networks are not swarm-scoped")
+}
+
+func (d *DockerPluginServer) DeleteNetwork(r *network.DeleteNetworkRequest) error {
+	return d.core.DeleteNetwork(r.NetworkID)
+}
+
+func (d *DockerPluginServer) FreeNetwork(r *network.FreeNetworkRequest) error {
+	return fmt.Errorf("FreeNetwork isn't implemented: networks are not swarm-scoped")
+}
+
+func (d *DockerPluginServer) CreateEndpoint(
+	r *network.CreateEndpointRequest) (*network.CreateEndpointResponse, error) {
+
+	preferredIP := ""
+	if r.Interface != nil {
+		preferredIP = r.Interface.Address
+	}
+
+	opts := driver_core.CreateEndpointOptions{
+		QoS: qosOptions(r.Options),
+		DNS: dnsOptions(r.Options),
+	}
+
+	ep, err := d.core.CreateEndpoint(r.NetworkID, r.EndpointID, preferredIP, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &network.CreateEndpointResponse{
+		Interface: &network.EndpointInterface{
+			Address:    ep.IPAddress,
+			MacAddress: ep.MacAddress,
+		},
+	}, nil
+}
+
+func (d *DockerPluginServer) DeleteEndpoint(r *network.DeleteEndpointRequest) error {
+	return d.core.DeleteEndpoint(r.EndpointID)
+}
+
+// EndpointOperInfo answers the "/NetworkDriver.EndpointOperInfo" request,
+// which backs `docker network inspect`'s per-endpoint runtime data. It
+// surfaces both the HNS and Contrail state backing the endpoint.
+func (d *DockerPluginServer) EndpointOperInfo(
+	r *network.InfoRequest) (*network.InfoResponse, error) {
+
+	ep, err := d.core.GetGlobalEndpoint(r.NetworkID, r.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, &driver_core.EndpointNotFoundError{ID: r.EndpointID}
+	}
+
+	value := map[string]string{
+		"hns.endpoint_id":                        ep.HNSID,
+		"com.docker.network.endpoint.macaddress": ep.MacAddress,
+		"contrail.vmi_uuid":                      ep.ContrailVMIUUID,
+		"contrail.instance_ip":                   ep.IPAddress,
+		"contrail.mac":                           ep.MacAddress,
+		"contrail.gateway":                       ep.Gateway,
+	}
+
+	if localNet, err := d.core.GetNetwork(ep.DockerNetID); err != nil {
+		return nil, err
+	} else if localNet != nil {
+		value["contrail.vn_fq_name"] = strings.Join(
+			[]string{common.DomainName, localNet.Tenant, localNet.Network}, ":")
+	}
+	if hnsNet, err := hns.GetHNSNetwork(ep.HNSNetworkID); err == nil && hnsNet != nil {
+		value["hns.switch_name"] = hnsNet.Name
+	}
+
+	if ep.Host != "" {
+		value["com.docker.network.endpoint.host"] = ep.Host
+	}
+	if ep.FloatingIPAddress != "" {
+		value["contrail.floating-ip.address"] = ep.FloatingIPAddress
+	}
+
+	return &network.InfoResponse{Value: value}, nil
+}
+
+func (d *DockerPluginServer) Join(r *network.JoinRequest) (*network.JoinResponse, error) {
+	ep, err := d.core.GetGlobalEndpoint(r.NetworkID, r.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, &driver_core.EndpointNotFoundError{ID: r.EndpointID}
+	}
+	if ep.Host != "" && ep.Host != d.core.Hostname() {
+		return nil, &driver_core.EndpointOwnedByOtherHostError{ID: r.EndpointID, Host: ep.Host}
+	}
+
+	return &network.JoinResponse{
+		Gateway:               ep.Gateway,
+		DisableGatewayService: true,
+	}, nil
+}
+
+func (d *DockerPluginServer) Leave(r *network.LeaveRequest) error {
+	ep, err := d.core.GetGlobalEndpoint(r.NetworkID, r.EndpointID)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return &driver_core.EndpointNotFoundError{ID: r.EndpointID}
+	}
+	if ep.Host != "" && ep.Host != d.core.Hostname() {
+		return &driver_core.EndpointOwnedByOtherHostError{ID: r.EndpointID, Host: ep.Host}
+	}
+	return nil
+}
+
+// portBinding mirrors the shape docker sends under the
+// "com.docker.network.portmap" option: one entry per `-p hostPort:port`.
+type portBinding struct {
+	Proto    string
+	Port     uint16
+	HostPort uint16
+	HostIP   string
+}
+
+func portBindings(opts map[string]interface{}) ([]portBinding, error) {
+	raw, ok := opts["com.docker.network.portmap"]
+	if !ok {
+		return nil, nil
+	}
+	// Options values decode as generic interface{}; round-trip through JSON
+	// to get them into our typed portBinding slice.
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var bindings []portBinding
+	if err := json.Unmarshal(encoded, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+func (d *DockerPluginServer) ProgramExternalConnectivity(
+	r *network.ProgramExternalConnectivityRequest) error {
+
+	bindings, err := portBindings(r.Options)
+	if err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	floatingIP, err := d.core.ProgramExternalConnectivity(r.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	ep, err := d.core.GetEndpoint(r.EndpointID)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return &driver_core.EndpointNotFoundError{ID: r.EndpointID}
+	}
+
+	var policies []json.RawMessage
+	for _, b := range bindings {
+		proto := b.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		policy, err := hns.ELBPolicy(proto, b.Port, b.HostPort, floatingIP)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, policy)
+	}
+
+	return hns.AddEndpointPolicies(ep.HNSID, policies)
+}
+
+func (d *DockerPluginServer) RevokeExternalConnectivity(
+	r *network.RevokeExternalConnectivityRequest) error {
+
+	if err := d.core.RevokeExternalConnectivity(r.EndpointID); err != nil {
+		return err
+	}
+
+	ep, err := d.core.GetEndpoint(r.EndpointID)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return nil
+	}
+
+	return hns.RemoveELBPolicies(ep.HNSID)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/docker_libnetwork_plugin"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hyperv_extension"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/contrail_ipam"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns/win_networking"
 	netSim "github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/simulator"
@@ -652,45 +653,6 @@ var _ = PDescribe("On requests from docker daemon", func() {
 		})
 	})
 
-	Context("on EndpointInfo request", func() {
-
-		dockerNetID := ""
-		containerID := ""
-		var req *network.InfoRequest
-
-		BeforeEach(func() {
-			_, dockerNetID, containerID = setupNetworksAndEndpoints(contrailController, docker)
-			dockerNet, err := getDockerNetwork(docker, dockerNetID)
-			Expect(err).ToNot(HaveOccurred())
-			req = &network.InfoRequest{
-				NetworkID:  dockerNetID,
-				EndpointID: dockerNet.Containers[containerID].EndpointID,
-			}
-		})
-
-		Context("queried endpoint exists", func() {
-			It("responds with proper InfoResponse", func() {
-				resp, err := server.EndpointInfo(req)
-				Expect(err).ToNot(HaveOccurred())
-
-				hnsEndpoint, _ := getTheOnlyHNSEndpoint(server)
-				Expect(resp.Value).To(HaveKeyWithValue("hnsid", hnsEndpoint.Id))
-				Expect(resp.Value).To(HaveKeyWithValue(
-					"com.docker.network.endpoint.macaddress", hnsEndpoint.MacAddress))
-			})
-		})
-
-		Context("queried endpoint doesn't exist", func() {
-			BeforeEach(func() {
-				deleteTheOnlyHNSEndpoint(server)
-			})
-			It("responds with err", func() {
-				_, err := server.EndpointInfo(req)
-				Expect(err).To(HaveOccurred())
-			})
-		})
-	})
-
 	Context("on Join request", func() {
 
 		dockerNetID := ""
@@ -823,7 +785,7 @@ func newIntegrationModulesUnderTest() (vr ports.VRouter, d *docker_libnetwork_pl
 	serverUrl, _ := url.Parse("http://127.0.0.1:9091")
 	a := agent.NewAgentRestAPI(http.DefaultClient, serverUrl)
 
-	driverCore, err := driver_core.NewContrailDriverCore(vr, c, a, netRepo, epRepo)
+	driverCore, err := driver_core.NewContrailDriverCore(vr, c, a, contrail_ipam.NewContrailIPAM(), netRepo, epRepo)
 	Expect(err).ToNot(HaveOccurred())
 	d = docker_libnetwork_plugin.NewDockerPluginServer(driverCore)
 
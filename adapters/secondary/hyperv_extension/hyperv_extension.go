@@ -17,8 +17,10 @@ package hyperv_extension
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -89,6 +91,10 @@ func (hvvr *hyperVvRouterForwardingExtension) callOnSwitch(command string, optio
 	for _, opt := range optionals {
 		c = append(c, opt)
 	}
+
+	start := time.Now()
 	stdout, _, err := common.CallPowershell(c...)
+	telemetry.ObservePowerShellLatency(time.Since(start))
+
 	return stdout, err
 }
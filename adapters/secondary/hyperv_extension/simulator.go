@@ -0,0 +1,42 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hyperv_extension
+
+// HyperVExtensionSimulator is a fake of hyperVvRouterForwardingExtension that
+// doesn't shell out to PowerShell. It's used by unit/integration tests that
+// exercise driver_core without a real Hyper-V switch.
+type HyperVExtensionSimulator struct {
+	Enabled bool
+	Running bool
+}
+
+func (s *HyperVExtensionSimulator) Enable() error {
+	s.Enabled = true
+	return nil
+}
+
+func (s *HyperVExtensionSimulator) Disable() error {
+	s.Enabled = false
+	return nil
+}
+
+func (s *HyperVExtensionSimulator) IsEnabled() (bool, error) {
+	return s.Enabled, nil
+}
+
+func (s *HyperVExtensionSimulator) IsRunning() (bool, error) {
+	return s.Running, nil
+}
@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package contrail_ipam implements ports.IPAM as a thin pass-through onto
+// Contrail: the actual InstanceIp is minted by ports.Controller, called
+// directly by driver_core, so this backend just validates the pool handle
+// and hands addresses through unchanged. It's the default IPAM backend,
+// preserving the driver's pre-existing, Contrail-only allocation behavior.
+package contrail_ipam
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContrailIPAM implements ports.IPAM with Contrail as the sole allocator.
+type ContrailIPAM struct{}
+
+// NewContrailIPAM returns the default ports.IPAM backend.
+func NewContrailIPAM() *ContrailIPAM {
+	return &ContrailIPAM{}
+}
+
+// pool round-trips a tenant/network pair through RequestAddress/
+// ReleaseAddress/ReleasePool as an opaque poolID string.
+type pool struct {
+	Tenant  string
+	Network string
+}
+
+func (p pool) String() string {
+	return p.Tenant + ":" + p.Network
+}
+
+func parsePool(poolID string) (pool, error) {
+	parts := strings.SplitN(poolID, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return pool{}, fmt.Errorf("malformed pool ID: %s", poolID)
+	}
+	return pool{Tenant: parts[0], Network: parts[1]}, nil
+}
+
+// RequestPool returns tenant:network as the pool handle. IPRange/Exclude
+// aren't supported: Contrail's own IPAM owns the subnet and has no notion of
+// a sub-range carved out of it by this driver.
+func (c *ContrailIPAM) RequestPool(tenant, network, subnetCIDR string,
+	opts ports.IPAMPoolOptions) (string, error) {
+
+	if tenant == "" || network == "" {
+		err := fmt.Errorf("tenant and network are required")
+		log.Errorln(err)
+		return "", err
+	}
+	if opts.IPRange != "" || len(opts.Exclude) > 0 {
+		err := fmt.Errorf("ip_range/exclude_addresses require the \"local\" IPAM backend")
+		log.Errorln(err)
+		return "", err
+	}
+	return pool{Tenant: tenant, Network: network}.String(), nil
+}
+
+// ReleasePool is a no-op: the pool is just tenant:network, owned by whoever
+// created the Contrail virtual-network in Contrail, not by this backend.
+func (c *ContrailIPAM) ReleasePool(poolID string) error {
+	_, err := parsePool(poolID)
+	return err
+}
+
+// RequestAddress hands opts.Address straight back, leaving Contrail's
+// AllocateInstanceIp (called next, by driver_core) to actually allocate the
+// InstanceIp, whether opts.Address pins it or it's left empty for Contrail
+// to pick.
+func (c *ContrailIPAM) RequestAddress(poolID, containerID string,
+	opts ports.IPAMAddressOptions) (string, error) {
+
+	if _, err := parsePool(poolID); err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	return opts.Address, nil
+}
+
+// ReleaseAddress is a no-op: releasing the underlying Contrail InstanceIp is
+// handled separately, by driver_core.DeleteEndpoint's ReleaseInstanceIp
+// call, not by this interface.
+func (c *ContrailIPAM) ReleaseAddress(poolID, address string) error {
+	_, err := parsePool(poolID)
+	return err
+}
@@ -0,0 +1,387 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package local_ipam implements ports.IPAM as a local bitmap allocator,
+// persisted in a bbolt database, for hosts that want the address handed to
+// Contrail's AllocateInstanceIp picked locally instead of by Contrail
+// itself — e.g. to honor an "ip_range" sub-range or "exclude_addresses" list
+// Contrail's own IPAM has no notion of.
+package local_ipam
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultDBPath is where LocalIPAM persists its allocation bitmaps by
+// default: one bbolt database shared across every pool this host manages.
+func DefaultDBPath() string {
+	return filepath.Join(os.Getenv("ProgramData"), "Contrail", "ipam.db")
+}
+
+var poolsBucket = []byte("pools")
+
+const (
+	metaKey   = "meta"
+	bitmapKey = "bitmap"
+	leasesKey = "leases"
+)
+
+// poolMeta is the per-pool metadata persisted alongside the allocation
+// bitmap, so RequestAddress/ReleaseAddress can translate an address to/from
+// a bit index without RequestPool being called again.
+type poolMeta struct {
+	RangeStart uint32
+	RangeEnd   uint32
+}
+
+// LocalIPAM implements ports.IPAM with a bitmap of used addresses per pool,
+// persisted in a bbolt database.
+type LocalIPAM struct {
+	mu sync.Mutex
+	db *bolt.DB
+}
+
+// NewLocalIPAM opens (creating if necessary) the bbolt database at path.
+func NewLocalIPAM(path string) (*LocalIPAM, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	return &LocalIPAM{db: db}, nil
+}
+
+// Close releases the underlying bbolt database.
+func (l *LocalIPAM) Close() error {
+	return l.db.Close()
+}
+
+// pool round-trips a tenant/network pair through RequestAddress/
+// ReleaseAddress/ReleasePool as an opaque poolID string, same convention as
+// contrail_ipam.
+type pool struct {
+	Tenant  string
+	Network string
+}
+
+func (p pool) String() string {
+	return p.Tenant + ":" + p.Network
+}
+
+// RequestPool registers subnetCIDR (optionally narrowed to opts.IPRange,
+// with opts.Exclude withheld from it) for allocation. Calling it again for a
+// pool that already exists (e.g. after a restart, or a second docker network
+// referencing the same Contrail VN) reuses it as-is.
+func (l *LocalIPAM) RequestPool(tenant, network, subnetCIDR string,
+	opts ports.IPAMPoolOptions) (string, error) {
+
+	if tenant == "" || network == "" {
+		err := fmt.Errorf("tenant and network are required")
+		log.Errorln(err)
+		return "", err
+	}
+
+	_, ipNet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+
+	start, end := firstLastHost(ipNet)
+	if opts.IPRange != "" {
+		rangeStart, rangeEnd, err := parseRange(opts.IPRange)
+		if err != nil {
+			log.Errorln(err)
+			return "", err
+		}
+		if !ipNet.Contains(uint32ToIP(rangeStart)) || !ipNet.Contains(uint32ToIP(rangeEnd)) {
+			err := fmt.Errorf("ip_range %s isn't contained in subnet %s", opts.IPRange, subnetCIDR)
+			log.Errorln(err)
+			return "", err
+		}
+		start, end = rangeStart, rangeEnd
+	}
+
+	poolID := pool{Tenant: tenant, Network: network}.String()
+	size := end - start + 1
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(poolsBucket)
+		if err != nil {
+			return err
+		}
+		pb, err := b.CreateBucketIfNotExists([]byte(poolID))
+		if err != nil {
+			return err
+		}
+		if pb.Get([]byte(metaKey)) != nil {
+			return nil
+		}
+
+		meta, err := json.Marshal(poolMeta{RangeStart: start, RangeEnd: end})
+		if err != nil {
+			return err
+		}
+		if err := pb.Put([]byte(metaKey), meta); err != nil {
+			return err
+		}
+
+		bitmap := make([]byte, (size+7)/8)
+		for _, excl := range opts.Exclude {
+			exStart, exEnd, err := addressOrRange(excl)
+			if err != nil {
+				return err
+			}
+			for addr := exStart; addr <= exEnd; addr++ {
+				if addr < start || addr > end {
+					continue
+				}
+				setBit(bitmap, addr-start)
+			}
+		}
+		return pb.Put([]byte(bitmapKey), bitmap)
+	})
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	return poolID, nil
+}
+
+// ReleasePool deletes poolID's bitmap and lease state.
+func (l *LocalIPAM) ReleasePool(poolID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(poolsBucket)
+		if b == nil || b.Bucket([]byte(poolID)) == nil {
+			return nil
+		}
+		return b.DeleteBucket([]byte(poolID))
+	})
+}
+
+// RequestAddress picks the next free address in poolID's bitmap, or pins
+// opts.Address if given, and leases it to containerID.
+func (l *LocalIPAM) RequestAddress(poolID, containerID string,
+	opts ports.IPAMAddressOptions) (string, error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var address string
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		pb, meta, err := openPool(tx, poolID)
+		if err != nil {
+			return err
+		}
+		bitmap := append([]byte(nil), pb.Get([]byte(bitmapKey))...)
+
+		var idx uint32
+		if opts.Address != "" {
+			addr, err := ipToUint32(opts.Address)
+			if err != nil {
+				return err
+			}
+			if addr < meta.RangeStart || addr > meta.RangeEnd {
+				return fmt.Errorf("address %s is outside pool %s's range", opts.Address, poolID)
+			}
+			idx = addr - meta.RangeStart
+			if testBit(bitmap, idx) {
+				return fmt.Errorf("address %s is already in use", opts.Address)
+			}
+		} else {
+			size := meta.RangeEnd - meta.RangeStart + 1
+			found := false
+			for i := uint32(0); i < size; i++ {
+				if !testBit(bitmap, i) {
+					idx, found = i, true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("pool %s is exhausted", poolID)
+			}
+		}
+
+		setBit(bitmap, idx)
+		if err := pb.Put([]byte(bitmapKey), bitmap); err != nil {
+			return err
+		}
+
+		leases, err := pb.CreateBucketIfNotExists([]byte(leasesKey))
+		if err != nil {
+			return err
+		}
+		address = uint32ToIP(meta.RangeStart + idx).String()
+		return leases.Put([]byte(containerID), []byte(address))
+	})
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	return address, nil
+}
+
+// ReleaseAddress frees address in poolID's bitmap, and forgets any lease
+// that held it. It's a no-op if the pool is already gone.
+func (l *LocalIPAM) ReleaseAddress(poolID, address string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.db.Update(func(tx *bolt.Tx) error {
+		pb, meta, err := openPool(tx, poolID)
+		if err != nil {
+			return nil
+		}
+
+		addr, err := ipToUint32(address)
+		if err != nil {
+			return err
+		}
+		if addr < meta.RangeStart || addr > meta.RangeEnd {
+			return nil
+		}
+
+		bitmap := append([]byte(nil), pb.Get([]byte(bitmapKey))...)
+		clearBit(bitmap, addr-meta.RangeStart)
+		if err := pb.Put([]byte(bitmapKey), bitmap); err != nil {
+			return err
+		}
+
+		leases := pb.Bucket([]byte(leasesKey))
+		if leases == nil {
+			return nil
+		}
+		c := leases.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if string(v) == address {
+				return leases.Delete(k)
+			}
+		}
+		return nil
+	})
+}
+
+// openPool looks up poolID's bucket and decodes its metadata, failing if
+// RequestPool hasn't been called for it (or ReleasePool already tore it
+// down).
+func openPool(tx *bolt.Tx, poolID string) (*bolt.Bucket, poolMeta, error) {
+	b := tx.Bucket(poolsBucket)
+	if b == nil {
+		return nil, poolMeta{}, fmt.Errorf("pool %s doesn't exist", poolID)
+	}
+	pb := b.Bucket([]byte(poolID))
+	if pb == nil {
+		return nil, poolMeta{}, fmt.Errorf("pool %s doesn't exist", poolID)
+	}
+	var meta poolMeta
+	if err := json.Unmarshal(pb.Get([]byte(metaKey)), &meta); err != nil {
+		return nil, poolMeta{}, err
+	}
+	return pb, meta, nil
+}
+
+// firstLastHost returns the first and last usable host addresses within
+// ipNet (excluding the network and broadcast addresses), as uint32.
+func firstLastHost(ipNet *net.IPNet) (first, last uint32) {
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	ones, bits := ipNet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	if size < 4 {
+		// Degenerate /31 or /32: treat the whole range as usable.
+		return base, base + size - 1
+	}
+	return base + 1, base + size - 2
+}
+
+// parseRange parses a "<start>-<end>" range string into inclusive uint32
+// bounds.
+func parseRange(s string) (start, end uint32, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed range %q, want \"<start>-<end>\"", s)
+	}
+	start, err = ipToUint32(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = ipToUint32(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("range %q has start after end", s)
+	}
+	return start, end, nil
+}
+
+// addressOrRange parses s as either a single address or a "<start>-<end>"
+// range, as accepted by the "exclude_addresses" driver-opt.
+func addressOrRange(s string) (start, end uint32, err error) {
+	if strings.Contains(s, "-") {
+		return parseRange(s)
+	}
+	addr, err := ipToUint32(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return addr, addr, nil
+}
+
+func ipToUint32(s string) (uint32, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid IPv4 address %q", s)
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+func uint32ToIP(v uint32) net.IP {
+	b := make(net.IP, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func setBit(bitmap []byte, idx uint32) {
+	bitmap[idx/8] |= 1 << (idx % 8)
+}
+
+func clearBit(bitmap []byte, idx uint32) {
+	bitmap[idx/8] &^= 1 << (idx % 8)
+}
+
+func testBit(bitmap []byte, idx uint32) bool {
+	return bitmap[idx/8]&(1<<(idx%8)) != 0
+}
@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local_networking_test
+
+import (
+	"testing"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hcn"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func TestBackends(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Local networking backends test suite")
+}
+
+// newBackend builds one of the driver's interchangeable Windows networking
+// backends, wired to its respective in-memory simulator instead of a real
+// HNS/HCN host, so both can be exercised through the same
+// ports.LocalContrailNetworkRepository/ports.EndpointRepository suite below.
+type newBackend func() hns.Backend
+
+func newSimulatedHNSBackend() hns.Backend {
+	backend, err := hns.NewHNSBackend("Ethernet0", "Layered Ethernet0",
+		&contrail_networking.NetworkingSimulator{})
+	Expect(err).ToNot(HaveOccurred())
+	return backend
+}
+
+func newSimulatedHCNBackend() hns.Backend {
+	return hcn.NewHCNBackend("Ethernet0", &hcn.NetworkingSimulator{})
+}
+
+var _ = DescribeTable("a Windows networking backend",
+	func(newBackend newBackend) {
+		backend := newBackend()
+		netRepo := backend.NetworkRepository()
+		epRepo := backend.EndpointRepository()
+
+		netw := &ports.LocalNetwork{
+			ID:              "test-net-id",
+			ContrailNetUUID: "11111111-1111-1111-1111-111111111111",
+			Tenant:          "agatka",
+			Network:         "test_net",
+			Subnet:          "1.2.3.4/24",
+			Gateway:         "1.2.3.1",
+		}
+		Expect(netRepo.AddNetwork(netw)).To(Succeed())
+		Expect(netw.HNSID).ToNot(BeEmpty())
+
+		fetchedNet, err := netRepo.GetNetwork("test-net-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetchedNet).ToNot(BeNil())
+		Expect(fetchedNet.HNSID).To(Equal(netw.HNSID))
+
+		ep := &ports.LocalEndpoint{
+			ID:              "test-ep-id",
+			DockerNetID:     "test-net-id",
+			HNSNetworkID:    netw.HNSID,
+			ContrailVMIUUID: "22222222-2222-2222-2222-222222222222",
+			IPAddress:       "1.2.3.4",
+			Gateway:         "1.2.3.1",
+		}
+		Expect(epRepo.AddEndpoint(ep)).To(Succeed())
+		Expect(ep.HNSID).ToNot(BeEmpty())
+		Expect(ep.MacAddress).ToNot(BeEmpty())
+
+		fetchedEp, err := epRepo.GetEndpoint("test-ep-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetchedEp).ToNot(BeNil())
+		Expect(fetchedEp.HNSID).To(Equal(ep.HNSID))
+
+		Expect(epRepo.DeleteEndpoint("test-ep-id")).To(Succeed())
+		fetchedEp, err = epRepo.GetEndpoint("test-ep-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetchedEp).To(BeNil())
+
+		Expect(netRepo.DeleteNetwork("test-net-id")).To(Succeed())
+		fetchedNet, err = netRepo.GetNetwork("test-net-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetchedNet).To(BeNil())
+	},
+	Entry("legacy HNS", newBackend(newSimulatedHNSBackend)),
+	Entry("HCN v2", newBackend(newSimulatedHCNBackend)),
+)
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package contrail_networking isolates every call the local_networking
+// adapters make into Windows HNS (and the adapter reacquiring its IP
+// afterwards) behind a single interface, so driver_core and the local
+// network/endpoint repositories can be exercised against
+// NetworkingSimulator instead of a real HNS host.
+package contrail_networking
+
+//go:generate mockgen -package mocknet -destination mocknet/networking_mock.go github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking Networking
+
+import (
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Microsoft/hcsshim"
+)
+
+// Networking is every HNS operation HNSContrailNetworksRepository and
+// HNSEndpointRepository need, plus the adapter IP-reacquisition wait HNS
+// network creation/deletion can trigger.
+type Networking interface {
+	CreateNetwork(config *hcsshim.HNSNetwork) (string, error)
+	DeleteNetwork(hnsID string) error
+	GetNetworkByName(name string) (*hcsshim.HNSNetwork, error)
+	ListNetworks() ([]hcsshim.HNSNetwork, error)
+
+	CreateEndpoint(config *hcsshim.HNSEndpoint) (string, error)
+	DeleteEndpoint(endpointID string) error
+	GetEndpoint(endpointID string) (*hcsshim.HNSEndpoint, error)
+	ListEndpoints() ([]hcsshim.HNSEndpoint, error)
+	ListEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error)
+
+	// WaitForValidIP blocks until adapter has reacquired a valid IPv4
+	// address, or returns an error if it times out first.
+	WaitForValidIP(adapter common.AdapterName) error
+
+	// EnableExtension, DisableExtension, IsExtensionEnabled and
+	// IsExtensionRunning fold hyperv_extension's PowerShell-driven vRouter
+	// forwarding extension management behind this interface too, so
+	// InitRootHNSNetwork can be exercised against NetworkingSimulator
+	// without a real Hyper-V switch.
+	EnableExtension(vswitchName string) error
+	DisableExtension(vswitchName string) error
+	IsExtensionEnabled(vswitchName string) (bool, error)
+	IsExtensionRunning(vswitchName string) (bool, error)
+}
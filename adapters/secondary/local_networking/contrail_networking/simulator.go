@@ -0,0 +1,191 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contrail_networking
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Microsoft/hcsshim"
+)
+
+// NetworkingSimulator is a fake Networking that keeps networks and endpoints
+// in memory instead of calling into real HNS, mirroring
+// hyperv_extension.HyperVExtensionSimulator. It's used by unit tests that
+// exercise driver_core/hns without a real Windows host.
+type NetworkingSimulator struct {
+	mu     sync.Mutex
+	nextID int
+
+	networks  map[string]hcsshim.HNSNetwork
+	endpoints map[string]hcsshim.HNSEndpoint
+
+	enabledExtensions map[string]bool
+}
+
+func (s *NetworkingSimulator) genID() string {
+	s.nextID++
+	return fmt.Sprintf("sim-%d", s.nextID)
+}
+
+func (s *NetworkingSimulator) CreateNetwork(config *hcsshim.HNSNetwork) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.networks == nil {
+		s.networks = make(map[string]hcsshim.HNSNetwork)
+	}
+	netw := *config
+	netw.Id = s.genID()
+	s.networks[netw.Id] = netw
+	return netw.Id, nil
+}
+
+func (s *NetworkingSimulator) DeleteNetwork(hnsID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.networks[hnsID]; !ok {
+		return fmt.Errorf("network %s doesn't exist", hnsID)
+	}
+	delete(s.networks, hnsID)
+	return nil
+}
+
+func (s *NetworkingSimulator) GetNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, netw := range s.networks {
+		if netw.Name == name {
+			found := netw
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *NetworkingSimulator) ListNetworks() ([]hcsshim.HNSNetwork, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nets := make([]hcsshim.HNSNetwork, 0, len(s.networks))
+	for _, netw := range s.networks {
+		nets = append(nets, netw)
+	}
+	return nets, nil
+}
+
+func (s *NetworkingSimulator) CreateEndpoint(config *hcsshim.HNSEndpoint) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.endpoints == nil {
+		s.endpoints = make(map[string]hcsshim.HNSEndpoint)
+	}
+	ep := *config
+	ep.Id = s.genID()
+	if ep.MacAddress == "" {
+		ep.MacAddress = fmt.Sprintf("00-15-5D-52-00-%02X", len(s.endpoints)+1)
+	}
+	s.endpoints[ep.Id] = ep
+	return ep.Id, nil
+}
+
+func (s *NetworkingSimulator) DeleteEndpoint(endpointID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.endpoints[endpointID]; !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", endpointID)
+	}
+	delete(s.endpoints, endpointID)
+	return nil
+}
+
+func (s *NetworkingSimulator) GetEndpoint(endpointID string) (*hcsshim.HNSEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep, ok := s.endpoints[endpointID]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %s doesn't exist", endpointID)
+	}
+	return &ep, nil
+}
+
+func (s *NetworkingSimulator) ListEndpoints() ([]hcsshim.HNSEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	eps := make([]hcsshim.HNSEndpoint, 0, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
+func (s *NetworkingSimulator) ListEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var eps []hcsshim.HNSEndpoint
+	for _, ep := range s.endpoints {
+		if ep.VirtualNetwork == netID {
+			eps = append(eps, ep)
+		}
+	}
+	return eps, nil
+}
+
+// WaitForValidIP is a no-op: the simulator never touches a real adapter, so
+// there's no IP reacquisition to wait for.
+func (s *NetworkingSimulator) WaitForValidIP(adapter common.AdapterName) error {
+	return nil
+}
+
+func (s *NetworkingSimulator) EnableExtension(vswitchName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.enabledExtensions == nil {
+		s.enabledExtensions = make(map[string]bool)
+	}
+	s.enabledExtensions[vswitchName] = true
+	return nil
+}
+
+func (s *NetworkingSimulator) DisableExtension(vswitchName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.enabledExtensions, vswitchName)
+	return nil
+}
+
+func (s *NetworkingSimulator) IsExtensionEnabled(vswitchName string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enabledExtensions[vswitchName], nil
+}
+
+// IsExtensionRunning mirrors IsExtensionEnabled: the simulator has no
+// separate notion of a switch extension being enabled but not yet running.
+func (s *NetworkingSimulator) IsExtensionRunning(vswitchName string) (bool, error) {
+	return s.IsExtensionEnabled(vswitchName)
+}
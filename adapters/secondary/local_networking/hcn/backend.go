@@ -0,0 +1,48 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcn
+
+import (
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+)
+
+// hcnBackend implements hns.Backend on top of HCNContrailNetworksRepository
+// and HCNEndpointRepository, so main.go can select the HCN v2 API the same
+// way it selects the legacy HNS one.
+type hcnBackend struct {
+	netRepo *HCNContrailNetworksRepository
+	epRepo  *HCNEndpointRepository
+}
+
+// NewHCNBackend returns a hns.Backend for tracking docker networks/endpoints
+// with the HCN v2 API. Unlike NewHNSBackend, it doesn't need to bring up a
+// root network first.
+func NewHCNBackend(adapter common.AdapterName, networking Networking) hns.Backend {
+	return &hcnBackend{
+		netRepo: NewHCNContrailNetworksRepository(adapter, networking),
+		epRepo:  NewHCNEndpointRepository(networking),
+	}
+}
+
+func (b *hcnBackend) NetworkRepository() ports.LocalContrailNetworkRepository {
+	return b.netRepo
+}
+
+func (b *hcnBackend) EndpointRepository() ports.EndpointRepository {
+	return b.epRepo
+}
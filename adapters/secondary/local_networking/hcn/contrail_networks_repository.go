@@ -0,0 +1,277 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcn
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Microsoft/hcsshim/hcn"
+	log "github.com/sirupsen/logrus"
+)
+
+// hcnBackedNetwork tracks every docker network that shares a single
+// Contrail virtual-network (and therefore a single HCN network), so the HCN
+// network can be reference-counted for GlobalScope.
+type hcnBackedNetwork struct {
+	hcnID string
+	refs  map[string]*ports.LocalNetwork // dockerNetID -> LocalNetwork
+}
+
+// HCNContrailNetworksRepository implements ports.LocalContrailNetworkRepository
+// on top of the HCN v2 API, as an alternative to HNSContrailNetworksRepository.
+type HCNContrailNetworksRepository struct {
+	adapter    common.AdapterName
+	networking Networking
+
+	mu             sync.Mutex
+	byContrailUUID map[string]*hcnBackedNetwork
+	byDockerNetID  map[string]*ports.LocalNetwork
+}
+
+// NewHCNContrailNetworksRepository returns a repository for tracking docker
+// networks backed by HCN networks, created and destroyed through networking.
+// Unlike NewHNSContrailNetworksRepository, it doesn't need to bring up a root
+// network first: HCN networks don't implicitly create a vswitch the way the
+// legacy HNS API does. Pass NetworkingSimulator in tests to run without a
+// real HCN host.
+func NewHCNContrailNetworksRepository(adapter common.AdapterName,
+	networking Networking) *HCNContrailNetworksRepository {
+	return &HCNContrailNetworksRepository{
+		adapter:        adapter,
+		networking:     networking,
+		byContrailUUID: make(map[string]*hcnBackedNetwork),
+		byDockerNetID:  make(map[string]*ports.LocalNetwork),
+	}
+}
+
+// AddNetwork creates the HCN network backing netw, unless one already exists
+// for the same Contrail virtual-network UUID (GlobalScope), in which case it
+// just adds another reference to it.
+func (r *HCNContrailNetworksRepository) AddNetwork(netw *ports.LocalNetwork) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.byContrailUUID[netw.ContrailNetUUID]; ok {
+		netw.HNSID = existing.hcnID
+		existing.refs[netw.ID] = netw
+		r.byDockerNetID[netw.ID] = netw
+		log.Infof("Contrail VN %s already has HCN network %s, added reference for %s",
+			netw.ContrailNetUUID, existing.hcnID, netw.ID)
+		return nil
+	}
+
+	config := &hcn.HostComputeNetwork{
+		Name: common.HNSNetworkPrefix + "-" + netw.ContrailNetUUID,
+		Type: hcn.Transparent,
+		Ipams: []hcn.Ipam{
+			{
+				Subnets: []hcn.Subnet{
+					{
+						IpAddressPrefix: netw.Subnet,
+						Routes: []hcn.Route{
+							{
+								NextHop:           netw.Gateway,
+								DestinationPrefix: "0.0.0.0/0",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	hcnID, err := r.networking.CreateNetwork(config)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	netw.HNSID = hcnID
+	r.byContrailUUID[netw.ContrailNetUUID] = &hcnBackedNetwork{
+		hcnID: hcnID,
+		refs:  map[string]*ports.LocalNetwork{netw.ID: netw},
+	}
+	r.byDockerNetID[netw.ID] = netw
+	return nil
+}
+
+// DeleteNetwork drops dockerNetID's reference to its HCN network, deleting
+// the HCN network itself only once no docker network references it anymore.
+func (r *HCNContrailNetworksRepository) DeleteNetwork(dockerNetID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	netw, ok := r.byDockerNetID[dockerNetID]
+	if !ok {
+		return fmt.Errorf("network %s doesn't exist", dockerNetID)
+	}
+	delete(r.byDockerNetID, dockerNetID)
+
+	backed, ok := r.byContrailUUID[netw.ContrailNetUUID]
+	if !ok {
+		return nil
+	}
+	delete(backed.refs, dockerNetID)
+	if len(backed.refs) > 0 {
+		log.Infof("HCN network %s still has %d reference(s), not deleting", backed.hcnID,
+			len(backed.refs))
+		return nil
+	}
+
+	delete(r.byContrailUUID, netw.ContrailNetUUID)
+	return r.networking.DeleteNetwork(backed.hcnID)
+}
+
+// GetNetwork returns the local network registered for dockerNetID, or nil if
+// none is.
+func (r *HCNContrailNetworksRepository) GetNetwork(dockerNetID string) (*ports.LocalNetwork, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	netw, ok := r.byDockerNetID[dockerNetID]
+	if !ok {
+		return nil, nil
+	}
+	return netw, nil
+}
+
+// ListNetworks returns every network this repository currently tracks.
+func (r *HCNContrailNetworksRepository) ListNetworks() ([]ports.LocalNetwork, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nets := make([]ports.LocalNetwork, 0, len(r.byDockerNetID))
+	for _, netw := range r.byDockerNetID {
+		nets = append(nets, *netw)
+	}
+	return nets, nil
+}
+
+// HCNEndpointRepository implements ports.EndpointRepository on top of the
+// HCN v2 API, as an alternative to HNSEndpointRepository.
+type HCNEndpointRepository struct {
+	networking Networking
+
+	mu  sync.Mutex
+	eps map[string]*ports.LocalEndpoint // dockerEndpointID -> LocalEndpoint
+}
+
+// NewHCNEndpointRepository returns a repository for tracking HCN endpoints,
+// created and destroyed through networking. Pass NetworkingSimulator in
+// tests to run without a real HCN host.
+func NewHCNEndpointRepository(networking Networking) *HCNEndpointRepository {
+	return &HCNEndpointRepository{
+		networking: networking,
+		eps:        make(map[string]*ports.LocalEndpoint),
+	}
+}
+
+// AddEndpoint creates the HCN endpoint backing ep, pinning its IP address.
+func (r *HCNEndpointRepository) AddEndpoint(ep *ports.LocalEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.eps == nil {
+		r.eps = make(map[string]*ports.LocalEndpoint)
+	}
+
+	var routes []hcn.Route
+	for _, route := range ep.Routes {
+		routes = append(routes, hcn.Route{
+			DestinationPrefix: route.Destination,
+			NextHop:           route.NextHop,
+		})
+	}
+
+	config := &hcn.HostComputeEndpoint{
+		HostComputeNetwork: ep.HNSNetworkID,
+		IpConfigurations: []hcn.IpConfig{
+			{IpAddress: ep.IPAddress},
+		},
+		Routes: routes,
+	}
+	if ep.DNS != nil {
+		config.Dns = hcn.Dns{
+			Domain:     ep.DNS.Suffix,
+			Search:     ep.DNS.Search,
+			ServerList: ep.DNS.Servers,
+		}
+	}
+
+	hcnID, err := r.networking.CreateEndpoint(config)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	created, err := r.networking.GetEndpoint(hcnID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	ep.HNSID = hcnID
+	ep.MacAddress = created.MacAddress
+	r.eps[ep.ID] = ep
+	return nil
+}
+
+// UpdateEndpoint persists changes made to a *ports.LocalEndpoint previously
+// returned by GetEndpoint.
+func (r *HCNEndpointRepository) UpdateEndpoint(ep *ports.LocalEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.eps[ep.ID]; !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", ep.ID)
+	}
+	r.eps[ep.ID] = ep
+	return nil
+}
+
+// GetEndpoint returns the local endpoint registered for dockerEndpointID, or
+// nil if none is.
+func (r *HCNEndpointRepository) GetEndpoint(dockerEndpointID string) (*ports.LocalEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ep, ok := r.eps[dockerEndpointID]
+	if !ok {
+		return nil, nil
+	}
+	return ep, nil
+}
+
+// DeleteEndpoint deletes the HCN endpoint registered for dockerEndpointID.
+func (r *HCNEndpointRepository) DeleteEndpoint(dockerEndpointID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ep, ok := r.eps[dockerEndpointID]
+	if !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", dockerEndpointID)
+	}
+
+	if err := r.networking.DeleteEndpoint(ep.HNSID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	delete(r.eps, dockerEndpointID)
+	return nil
+}
@@ -0,0 +1,112 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hcn is an alternative to the hns package, backing docker networks
+// with the newer HCN (Host Compute Network) v2 API
+// (github.com/Microsoft/hcsshim/hcn) instead of the legacy HNS HTTP-over-RPC
+// shim. It's meant to be a drop-in replacement: callers pick one or the
+// other via ports.LocalContrailNetworkRepository/ports.EndpointRepository,
+// never hcsshim types directly.
+package hcn
+
+import (
+	"github.com/Microsoft/hcsshim/hcn"
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateHCNNetwork creates the HCN network described by configuration and
+// returns its ID.
+func CreateHCNNetwork(configuration *hcn.HostComputeNetwork) (string, error) {
+	log.Debugln("Creating HCN network:", configuration.Name)
+	created, err := configuration.Create()
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	log.Infoln("Created HCN network with ID:", created.Id)
+	return created.Id, nil
+}
+
+// DeleteHCNNetwork deletes the HCN network identified by hcnID.
+func DeleteHCNNetwork(hcnID string) error {
+	log.Infoln("Deleting HCN network", hcnID)
+	network, err := hcn.GetNetworkByID(hcnID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return network.Delete()
+}
+
+// GetHCNNetwork returns the HCN network identified by hcnID.
+func GetHCNNetwork(hcnID string) (*hcn.HostComputeNetwork, error) {
+	log.Debugln("Getting HCN network", hcnID)
+	network, err := hcn.GetNetworkByID(hcnID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	return network, nil
+}
+
+// GetHCNNetworkByName returns the HCN network named name, or nil if none
+// exists.
+func GetHCNNetworkByName(name string) (*hcn.HostComputeNetwork, error) {
+	log.Debugln("Getting HCN network by name:", name)
+	network, err := hcn.GetNetworkByName(name)
+	if err != nil {
+		if hcn.IsNotExist(err) {
+			return nil, nil
+		}
+		log.Errorln(err)
+		return nil, err
+	}
+	return network, nil
+}
+
+// CreateHCNEndpoint creates the HCN endpoint described by configuration and
+// returns its ID.
+func CreateHCNEndpoint(configuration *hcn.HostComputeEndpoint) (string, error) {
+	log.Debugln("Creating HCN endpoint")
+	created, err := configuration.Create()
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	log.Debugln("Created HCN endpoint with ID:", created.Id)
+	return created.Id, nil
+}
+
+// DeleteHCNEndpoint deletes the HCN endpoint identified by endpointID.
+func DeleteHCNEndpoint(endpointID string) error {
+	log.Debugln("Deleting HCN endpoint", endpointID)
+	endpoint, err := hcn.GetEndpointByID(endpointID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return endpoint.Delete()
+}
+
+// GetHCNEndpoint returns the HCN endpoint identified by endpointID.
+func GetHCNEndpoint(endpointID string) (*hcn.HostComputeEndpoint, error) {
+	log.Debugln("Getting HCN endpoint", endpointID)
+	endpoint, err := hcn.GetEndpointByID(endpointID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	return endpoint, nil
+}
@@ -0,0 +1,33 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcn
+
+import "github.com/Microsoft/hcsshim/hcn"
+
+// Networking is every HCN v2 API call HCNContrailNetworksRepository and
+// HCNEndpointRepository need, mirroring contrail_networking.Networking's role
+// for the legacy HNS backend: production code is wired to NetworkingAdapter,
+// while tests can be pointed at NetworkingSimulator to run without a real
+// Windows host.
+type Networking interface {
+	CreateNetwork(config *hcn.HostComputeNetwork) (string, error)
+	DeleteNetwork(hcnID string) error
+	GetNetworkByName(name string) (*hcn.HostComputeNetwork, error)
+
+	CreateEndpoint(config *hcn.HostComputeEndpoint) (string, error)
+	DeleteEndpoint(endpointID string) error
+	GetEndpoint(endpointID string) (*hcn.HostComputeEndpoint, error)
+}
@@ -0,0 +1,47 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcn
+
+import "github.com/Microsoft/hcsshim/hcn"
+
+// NetworkingAdapter implements Networking on top of this package's real HCN
+// calls, so HCNContrailNetworksRepository and HCNEndpointRepository can be
+// pointed at NetworkingSimulator in tests without a Windows host.
+type NetworkingAdapter struct{}
+
+func (NetworkingAdapter) CreateNetwork(config *hcn.HostComputeNetwork) (string, error) {
+	return CreateHCNNetwork(config)
+}
+
+func (NetworkingAdapter) DeleteNetwork(hcnID string) error {
+	return DeleteHCNNetwork(hcnID)
+}
+
+func (NetworkingAdapter) GetNetworkByName(name string) (*hcn.HostComputeNetwork, error) {
+	return GetHCNNetworkByName(name)
+}
+
+func (NetworkingAdapter) CreateEndpoint(config *hcn.HostComputeEndpoint) (string, error) {
+	return CreateHCNEndpoint(config)
+}
+
+func (NetworkingAdapter) DeleteEndpoint(endpointID string) error {
+	return DeleteHCNEndpoint(endpointID)
+}
+
+func (NetworkingAdapter) GetEndpoint(endpointID string) (*hcn.HostComputeEndpoint, error) {
+	return GetHCNEndpoint(endpointID)
+}
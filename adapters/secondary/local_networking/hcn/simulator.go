@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcn
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// NetworkingSimulator is a fake Networking that keeps networks and endpoints
+// in memory instead of calling into the real HCN v2 API, mirroring
+// contrail_networking.NetworkingSimulator. It's used by unit tests that
+// exercise driver_core/hcn without a real Windows host.
+type NetworkingSimulator struct {
+	mu     sync.Mutex
+	nextID int
+
+	networks  map[string]hcn.HostComputeNetwork
+	endpoints map[string]hcn.HostComputeEndpoint
+}
+
+func (s *NetworkingSimulator) genID() string {
+	s.nextID++
+	return fmt.Sprintf("sim-%d", s.nextID)
+}
+
+func (s *NetworkingSimulator) CreateNetwork(config *hcn.HostComputeNetwork) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.networks == nil {
+		s.networks = make(map[string]hcn.HostComputeNetwork)
+	}
+	netw := *config
+	netw.Id = s.genID()
+	s.networks[netw.Id] = netw
+	return netw.Id, nil
+}
+
+func (s *NetworkingSimulator) DeleteNetwork(hcnID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.networks[hcnID]; !ok {
+		return fmt.Errorf("network %s doesn't exist", hcnID)
+	}
+	delete(s.networks, hcnID)
+	return nil
+}
+
+func (s *NetworkingSimulator) GetNetworkByName(name string) (*hcn.HostComputeNetwork, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, netw := range s.networks {
+		if netw.Name == name {
+			found := netw
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *NetworkingSimulator) CreateEndpoint(config *hcn.HostComputeEndpoint) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.endpoints == nil {
+		s.endpoints = make(map[string]hcn.HostComputeEndpoint)
+	}
+	ep := *config
+	ep.Id = s.genID()
+	if ep.MacAddress == "" {
+		ep.MacAddress = fmt.Sprintf("00-15-5D-52-01-%02X", len(s.endpoints)+1)
+	}
+	s.endpoints[ep.Id] = ep
+	return ep.Id, nil
+}
+
+func (s *NetworkingSimulator) DeleteEndpoint(endpointID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.endpoints[endpointID]; !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", endpointID)
+	}
+	delete(s.endpoints, endpointID)
+	return nil
+}
+
+func (s *NetworkingSimulator) GetEndpoint(endpointID string) (*hcn.HostComputeEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep, ok := s.endpoints[endpointID]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %s doesn't exist", endpointID)
+	}
+	return &ep, nil
+}
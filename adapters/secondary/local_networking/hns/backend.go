@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+)
+
+// Backend bundles the ports.LocalContrailNetworkRepository/
+// ports.EndpointRepository pair needed to back docker networks with one of
+// the driver's interchangeable Windows networking APIs: the legacy HNS
+// HTTP-over-RPC shim (NewHNSBackend, this package) or the newer HCN v2 API
+// (hcn.NewHCNBackend). main.go selects between them with a single interface
+// value instead of switching on a networkBackend string at every call site
+// that needs a netRepo/epRepo pair.
+type Backend interface {
+	NetworkRepository() ports.LocalContrailNetworkRepository
+	EndpointRepository() ports.EndpointRepository
+}
+
+// hnsBackend implements Backend on top of HNSContrailNetworksRepository and
+// HNSEndpointRepository.
+type hnsBackend struct {
+	netRepo *HNSContrailNetworksRepository
+	epRepo  *HNSEndpointRepository
+}
+
+// NewHNSBackend brings up the root HNS network on adapter, enables the
+// vRouter forwarding extension on vswitchName, and returns a Backend for
+// tracking docker networks/endpoints with the legacy HNS API.
+func NewHNSBackend(adapter common.AdapterName, vswitchName string,
+	networking contrail_networking.Networking) (Backend, error) {
+
+	netRepo, err := NewHNSContrailNetworksRepository(adapter, vswitchName, networking)
+	if err != nil {
+		return nil, err
+	}
+	return &hnsBackend{
+		netRepo: netRepo,
+		epRepo:  NewHNSEndpointRepository(networking),
+	}, nil
+}
+
+func (b *hnsBackend) NetworkRepository() ports.LocalContrailNetworkRepository {
+	return b.netRepo
+}
+
+func (b *hnsBackend) EndpointRepository() ports.EndpointRepository {
+	return b.epRepo
+}
@@ -0,0 +1,393 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Microsoft/hcsshim"
+	log "github.com/sirupsen/logrus"
+)
+
+// endpointNameSep separates the two halves EndpointName encodes.
+const endpointNameSep = "|"
+
+// EndpointName encodes dockerEndpointID and vmiUUID into an HNS endpoint's
+// Name, the same way HNS network names encode their owning Contrail
+// virtual-network's UUID (see common.HNSNetworkPrefix). Unlike
+// HNSEndpointRepository.eps, an HNS endpoint's Name survives a driver
+// restart, so reconcile can recover both IDs from HNS state alone even
+// after the in-memory repository has been lost.
+func EndpointName(dockerEndpointID, vmiUUID string) string {
+	return dockerEndpointID + endpointNameSep + vmiUUID
+}
+
+// hnsBackedNetwork tracks every docker network that shares a single
+// Contrail virtual-network (and therefore a single HNS network), so the
+// HNS network can be reference-counted for GlobalScope.
+type hnsBackedNetwork struct {
+	hnsID string
+	refs  map[string]*ports.LocalNetwork // dockerNetID -> LocalNetwork
+}
+
+// HNSContrailNetworksRepository implements ports.LocalContrailNetworkRepository
+// on top of real HNS networks.
+type HNSContrailNetworksRepository struct {
+	adapter    common.AdapterName
+	networking contrail_networking.Networking
+
+	mu sync.Mutex
+	// byContrailUUID lets CreateNetwork in GlobalScope find an HNS network
+	// that's already backing the same Contrail virtual-network.
+	byContrailUUID map[string]*hnsBackedNetwork
+	byDockerNetID  map[string]*ports.LocalNetwork
+}
+
+// NewHNSContrailNetworksRepository brings up the root HNS network on adapter
+// (so a vswitch exists before any Contrail-backed network is created),
+// enables the vRouter forwarding extension on vswitchName, and returns a
+// repository for tracking them. networking is every HNS call the repository
+// makes afterwards; pass contrail_networking.NetworkingSimulator in tests to
+// run without a real HNS host.
+func NewHNSContrailNetworksRepository(adapter common.AdapterName, vswitchName string,
+	networking contrail_networking.Networking) (*HNSContrailNetworksRepository, error) {
+	if err := InitRootHNSNetwork(adapter, vswitchName, networking); err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	return &HNSContrailNetworksRepository{
+		adapter:        adapter,
+		networking:     networking,
+		byContrailUUID: make(map[string]*hnsBackedNetwork),
+		byDockerNetID:  make(map[string]*ports.LocalNetwork),
+	}, nil
+}
+
+// AddNetwork creates the HNS network backing net, unless one already exists
+// for the same Contrail virtual-network UUID (GlobalScope), in which case it
+// just adds another reference to it. The in-memory byContrailUUID map alone
+// can't be trusted for this: a CNI invocation execs a fresh process (and
+// therefore a fresh, empty repository) per command, so a second pod on a VN
+// this process has never seen would otherwise race CreateNetwork against an
+// HNS network that's already there. AddNetwork falls back to probing real
+// HNS state by name before creating anything.
+func (r *HNSContrailNetworksRepository) AddNetwork(netw *ports.LocalNetwork) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.byContrailUUID[netw.ContrailNetUUID]; ok {
+		netw.HNSID = existing.hnsID
+		existing.refs[netw.ID] = netw
+		r.byDockerNetID[netw.ID] = netw
+		log.Infof("Contrail VN %s already has HNS network %s, added reference for %s",
+			netw.ContrailNetUUID, existing.hnsID, netw.ID)
+		return nil
+	}
+
+	hnsName := common.HNSNetworkPrefix + "-" + netw.ContrailNetUUID
+	hnsNetwork, err := r.networking.GetNetworkByName(hnsName)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if hnsNetwork != nil {
+		netw.HNSID = hnsNetwork.Id
+		r.byContrailUUID[netw.ContrailNetUUID] = &hnsBackedNetwork{
+			hnsID: hnsNetwork.Id,
+			refs:  map[string]*ports.LocalNetwork{netw.ID: netw},
+		}
+		r.byDockerNetID[netw.ID] = netw
+		log.Infof("Contrail VN %s already has HNS network %s (rediscovered), added reference for %s",
+			netw.ContrailNetUUID, hnsNetwork.Id, netw.ID)
+		return nil
+	}
+
+	mode := NetworkMode(netw.NetworkMode)
+	if mode == "" {
+		mode = NetworkModeTransparent
+	}
+	if err := ValidateNetworkMode(mode); err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	subnets := []hcsshim.Subnet{
+		{
+			AddressPrefix:  netw.Subnet,
+			GatewayAddress: netw.Gateway,
+		},
+	}
+	config := &hcsshim.HNSNetwork{
+		Name:    hnsName,
+		Type:    string(mode),
+		Subnets: subnets,
+	}
+
+	// NAT networks are host-internal and don't bind to a physical adapter.
+	if mode != NetworkModeNAT {
+		config.NetworkAdapterName = string(r.adapter)
+	}
+
+	if mode == NetworkModeOverlay && netw.VSID == 0 {
+		err := fmt.Errorf("overlay network %s needs a VSID to encapsulate its traffic under", netw.ID)
+		log.Errorln(err)
+		return err
+	}
+
+	switch {
+	case len(netw.MacPools) > 0:
+		for _, pool := range netw.MacPools {
+			config.MacPools = append(config.MacPools, hcsshim.MacPool{
+				StartMacAddress: pool.Start,
+				EndMacAddress:   pool.End,
+			})
+		}
+	case mode == NetworkModeL2Bridge:
+		// l2bridge shares the host's MAC/IP rather than inheriting a MAC
+		// range from the physical adapter, so it needs an explicit MAC pool
+		// to hand out to endpoints, absent an operator-supplied one.
+		config.MacPools = []hcsshim.MacPool{
+			{
+				StartMacAddress: common.L2BridgeMacPoolStart,
+				EndMacAddress:   common.L2BridgeMacPoolEnd,
+			},
+		}
+	}
+
+	if netw.VLAN != 0 {
+		policy, err := VLANPolicy(netw.VLAN)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		config.Policies = append(config.Policies, policy)
+	}
+	if netw.VSID != 0 {
+		policy, err := VSIDPolicy(netw.VSID)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		config.Policies = append(config.Policies, policy)
+	}
+	if netw.OutboundNAT != nil {
+		policy, err := OutboundNATPolicy(netw.OutboundNAT.Exceptions)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		config.Policies = append(config.Policies, policy)
+	}
+
+	hnsID, err := r.networking.CreateNetwork(config)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	netw.HNSID = hnsID
+	r.byContrailUUID[netw.ContrailNetUUID] = &hnsBackedNetwork{
+		hnsID: hnsID,
+		refs:  map[string]*ports.LocalNetwork{netw.ID: netw},
+	}
+	r.byDockerNetID[netw.ID] = netw
+	return nil
+}
+
+// DeleteNetwork drops dockerNetID's reference to its HNS network, deleting
+// the HNS network itself only once no docker network references it anymore.
+func (r *HNSContrailNetworksRepository) DeleteNetwork(dockerNetID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	netw, ok := r.byDockerNetID[dockerNetID]
+	if !ok {
+		return fmt.Errorf("network %s doesn't exist", dockerNetID)
+	}
+	delete(r.byDockerNetID, dockerNetID)
+
+	backed, ok := r.byContrailUUID[netw.ContrailNetUUID]
+	if !ok {
+		return nil
+	}
+	delete(backed.refs, dockerNetID)
+	if len(backed.refs) > 0 {
+		log.Infof("HNS network %s still has %d reference(s), not deleting", backed.hnsID,
+			len(backed.refs))
+		return nil
+	}
+
+	delete(r.byContrailUUID, netw.ContrailNetUUID)
+	return r.networking.DeleteNetwork(backed.hnsID)
+}
+
+// GetNetwork returns the local network registered for dockerNetID, or nil if
+// none is.
+func (r *HNSContrailNetworksRepository) GetNetwork(dockerNetID string) (*ports.LocalNetwork, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	netw, ok := r.byDockerNetID[dockerNetID]
+	if !ok {
+		return nil, nil
+	}
+	return netw, nil
+}
+
+// ListNetworks returns every network this repository currently tracks.
+func (r *HNSContrailNetworksRepository) ListNetworks() ([]ports.LocalNetwork, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nets := make([]ports.LocalNetwork, 0, len(r.byDockerNetID))
+	for _, netw := range r.byDockerNetID {
+		nets = append(nets, *netw)
+	}
+	return nets, nil
+}
+
+// HNSEndpointRepository implements ports.EndpointRepository on top of real
+// HNS endpoints.
+type HNSEndpointRepository struct {
+	networking contrail_networking.Networking
+
+	mu  sync.Mutex
+	eps map[string]*ports.LocalEndpoint // dockerEndpointID -> LocalEndpoint
+}
+
+// NewHNSEndpointRepository returns a repository for tracking HNS endpoints,
+// created and destroyed through networking.
+func NewHNSEndpointRepository(networking contrail_networking.Networking) *HNSEndpointRepository {
+	return &HNSEndpointRepository{
+		networking: networking,
+		eps:        make(map[string]*ports.LocalEndpoint),
+	}
+}
+
+// AddEndpoint creates the HNS endpoint backing ep, pinning its IP address.
+func (r *HNSEndpointRepository) AddEndpoint(ep *ports.LocalEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.eps == nil {
+		r.eps = make(map[string]*ports.LocalEndpoint)
+	}
+
+	var policies []json.RawMessage
+	for _, route := range ep.Routes {
+		policy, err := RoutePolicy(route.Destination, route.NextHop)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		policies = append(policies, policy)
+	}
+	if ep.QoS != nil {
+		policy, err := QOSPolicy(ep.QoS.MaxBandwidthBytes, ep.QoS.Priority)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		policies = append(policies, policy)
+	}
+
+	config := &hcsshim.HNSEndpoint{
+		Name:           EndpointName(ep.ID, ep.ContrailVMIUUID),
+		VirtualNetwork: ep.HNSNetworkID,
+		IPAddress:      net.ParseIP(ep.IPAddress),
+		GatewayAddress: ep.Gateway,
+		Policies:       policies,
+	}
+	if ep.DNS != nil {
+		config.DNSServerList = strings.Join(ep.DNS.Servers, ",")
+		// Classic HNS has no separate search-list field; fold it into the
+		// suffix, which is the closest it gets to honoring it.
+		suffixes := ep.DNS.Search
+		if ep.DNS.Suffix != "" {
+			suffixes = append([]string{ep.DNS.Suffix}, suffixes...)
+		}
+		config.DNSSuffix = strings.Join(suffixes, ",")
+	}
+
+	hnsID, err := r.networking.CreateEndpoint(config)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	created, err := r.networking.GetEndpoint(hnsID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	ep.HNSID = hnsID
+	ep.MacAddress = created.MacAddress
+	r.eps[ep.ID] = ep
+	return nil
+}
+
+// UpdateEndpoint persists changes made to a *ports.LocalEndpoint previously
+// returned by GetEndpoint.
+func (r *HNSEndpointRepository) UpdateEndpoint(ep *ports.LocalEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.eps[ep.ID]; !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", ep.ID)
+	}
+	r.eps[ep.ID] = ep
+	return nil
+}
+
+// GetEndpoint returns the local endpoint registered for dockerEndpointID, or
+// nil if none is.
+func (r *HNSEndpointRepository) GetEndpoint(dockerEndpointID string) (*ports.LocalEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ep, ok := r.eps[dockerEndpointID]
+	if !ok {
+		return nil, nil
+	}
+	return ep, nil
+}
+
+// DeleteEndpoint deletes the HNS endpoint registered for dockerEndpointID.
+func (r *HNSEndpointRepository) DeleteEndpoint(dockerEndpointID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ep, ok := r.eps[dockerEndpointID]
+	if !ok {
+		return fmt.Errorf("endpoint %s doesn't exist", dockerEndpointID)
+	}
+
+	if err := r.networking.DeleteEndpoint(ep.HNSID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	delete(r.eps, dockerEndpointID)
+	return nil
+}
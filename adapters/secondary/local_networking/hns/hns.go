@@ -16,12 +16,15 @@
 package hns
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 	"time"
 
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns/win_networking"
 	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/telemetry"
 	"github.com/Microsoft/hcsshim"
 	log "github.com/sirupsen/logrus"
 )
@@ -34,15 +37,23 @@ func (e *recoverableError) Error() string {
 	return e.inner.Error()
 }
 
-func InitRootHNSNetwork(nameOfAdapterToUse common.AdapterName) error {
+// InitRootHNSNetwork brings up the dummy root HNS network on
+// nameOfAdapterToUse (creating the vswitch HNS derives from it, if one
+// doesn't already exist), then enables the vRouter forwarding extension on
+// vswitchName so traffic actually forwards through it.
+func InitRootHNSNetwork(nameOfAdapterToUse common.AdapterName, vswitchName string,
+	networking contrail_networking.Networking) error {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.InitRootHNSNetwork")
+	defer span.End()
+
 	// HNS automatically creates a new vswitch if the first HNS network is created. We want to
 	// control this behaviour. That's why we create a dummy root HNS network.
 
-	if err := win_networking.WaitForValidIPReacquisition(nameOfAdapterToUse); err != nil {
+	if err := networking.WaitForValidIP(nameOfAdapterToUse); err != nil {
 		return err
 	}
 
-	rootNetwork, err := GetHNSNetworkByName(common.RootNetworkName)
+	rootNetwork, err := networking.GetNetworkByName(common.RootNetworkName)
 	if err != nil {
 		return err
 	}
@@ -59,7 +70,7 @@ func InitRootHNSNetwork(nameOfAdapterToUse common.AdapterName) error {
 			NetworkAdapterName: string(nameOfAdapterToUse),
 			Subnets:            subnets,
 		}
-		rootNetID, err := CreateHNSNetwork(configuration)
+		rootNetID, err := networking.CreateNetwork(configuration)
 		if err != nil {
 			return err
 		}
@@ -68,10 +79,17 @@ func InitRootHNSNetwork(nameOfAdapterToUse common.AdapterName) error {
 	} else {
 		log.Infoln("Existing root HNS network found:", rootNetwork.Id)
 	}
+
+	if err := networking.EnableExtension(vswitchName); err != nil {
+		return err
+	}
 	return nil
 }
 
-func tryCreateHNSNetwork(config string) (string, error) {
+func tryCreateHNSNetwork(config string, mode NetworkMode) (string, error) {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.tryCreateHNSNetwork")
+	defer span.End()
+
 	response, err := hcsshim.HNSNetworkRequest("POST", "", config)
 	if err != nil {
 		log.Errorln(err)
@@ -84,6 +102,10 @@ func tryCreateHNSNetwork(config string) (string, error) {
 		}
 	}
 
+	if !mode.StealsAdapter() {
+		return response.Id, nil
+	}
+
 	// When the first HNS network is created, a vswitch is also created and attached to
 	// specified network adapter. This adapter will temporarily lose network connectivity
 	// while it reacquires IPv4. We need to wait for it.
@@ -103,6 +125,9 @@ func tryCreateHNSNetwork(config string) (string, error) {
 }
 
 func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.CreateHNSNetwork")
+	defer span.End()
+
 	log.Debugln("Creating HNS network")
 	configBytes, err := json.Marshal(configuration)
 	if err != nil {
@@ -111,15 +136,18 @@ func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
 	}
 	log.Debugln("Config:", string(configBytes))
 
+	mode := NetworkMode(configuration.Type)
+
 	var id = ""
 	delay := common.CreateHNSNetworkInitialRetryDelay
 	creatingStart := time.Now()
 	for {
-		id, err = tryCreateHNSNetwork(string(configBytes))
+		id, err = tryCreateHNSNetwork(string(configBytes), mode)
 		if err != nil {
 			if recoverableErr, ok := err.(*recoverableError); ok {
 				err = recoverableErr.inner
 				if time.Since(creatingStart) < common.CreateHNSNetworkTimeout {
+					telemetry.HNSNetworkCreateRetries.Add(1)
 					log.Warnln("Creating HNS network failed. Sleeping for ", delay, "ms before retrying.")
 					time.Sleep(delay)
 					delay *= 2
@@ -137,6 +165,9 @@ func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
 }
 
 func DeleteHNSNetwork(hnsID string) error {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.DeleteHNSNetwork")
+	defer span.End()
+
 	log.Infoln("Deleting HNS network", hnsID)
 
 	toDelete, err := GetHNSNetwork(hnsID)
@@ -166,7 +197,7 @@ func DeleteHNSNetwork(hnsID string) error {
 		return err
 	}
 
-	if !adapterStillInUse {
+	if !adapterStillInUse && NetworkMode(toDelete.Type).StealsAdapter() {
 		// If the last network that uses an adapter is deleted, then the underlying vswitch is
 		// also deleted. During this period, the adapter will temporarily lose network
 		// connectivity while it reacquires IPv4. We need to wait for it.
@@ -217,6 +248,11 @@ func GetHNSNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
 }
 
 func CreateHNSEndpoint(configuration *hcsshim.HNSEndpoint) (string, error) {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.CreateHNSEndpoint")
+	defer span.End()
+	start := time.Now()
+	defer func() { telemetry.ObserveEndpointCreateDuration(time.Since(start)) }()
+
 	log.Debugln("Creating HNS endpoint")
 	configBytes, err := json.Marshal(configuration)
 	if err != nil {
@@ -233,6 +269,11 @@ func CreateHNSEndpoint(configuration *hcsshim.HNSEndpoint) (string, error) {
 }
 
 func DeleteHNSEndpoint(endpointID string) error {
+	_, span := telemetry.Tracer().Start(context.Background(), "hns.DeleteHNSEndpoint")
+	defer span.End()
+	start := time.Now()
+	defer func() { telemetry.ObserveEndpointDeleteDuration(time.Since(start)) }()
+
 	log.Debugln("Deleting HNS endpoint", endpointID)
 	_, err := hcsshim.HNSEndpointRequest("DELETE", endpointID, "")
 	if err != nil {
@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import "fmt"
+
+// NetworkMode selects which Windows HNS network driver backs a docker
+// network. See the Windows libnetwork driver docs for the full semantics of
+// each mode.
+type NetworkMode string
+
+const (
+	// NetworkModeTransparent bridges every endpoint straight onto the
+	// physical adapter. This was the only mode this package supported
+	// before NetworkMode existed, and remains the default.
+	NetworkModeTransparent NetworkMode = "transparent"
+	// NetworkModeL2Bridge shares the host's IP and rewrites traffic at L2,
+	// useful for host-networked containers.
+	NetworkModeL2Bridge NetworkMode = "l2bridge"
+	// NetworkModeL2Tunnel forwards all traffic to a virtualization host.
+	NetworkModeL2Tunnel NetworkMode = "l2tunnel"
+	// NetworkModeNAT is a host-internal network NATed through the host's
+	// IP, useful for management overlays that don't need a physical
+	// adapter.
+	NetworkModeNAT NetworkMode = "nat"
+	// NetworkModeOverlay encapsulates traffic in VXLAN, identified by the
+	// network's VSID, so endpoints on different hosts can share a subnet
+	// without the physical network itself routing it.
+	NetworkModeOverlay NetworkMode = "overlay"
+)
+
+// ValidNetworkModes lists every NetworkMode ValidateNetworkMode accepts.
+var ValidNetworkModes = []NetworkMode{
+	NetworkModeTransparent,
+	NetworkModeL2Bridge,
+	NetworkModeL2Tunnel,
+	NetworkModeNAT,
+	NetworkModeOverlay,
+}
+
+// StealsAdapter reports whether mode takes sole ownership of the physical
+// adapter it's bound to, which temporarily knocks out the adapter's IP while
+// HNS rebuilds the vswitch underneath it
+// (https://github.com/Microsoft/hcsshim/issues/108). NAT and overlay
+// networks are host-internal/encapsulated and never do this, so callers can
+// skip waiting for IP reacquisition after creating or deleting one.
+func (mode NetworkMode) StealsAdapter() bool {
+	switch mode {
+	case NetworkModeNAT, NetworkModeOverlay:
+		return false
+	default:
+		return true
+	}
+}
+
+// ValidateNetworkMode returns an error unless mode is one of
+// ValidNetworkModes. Callers should default an empty mode to
+// NetworkModeTransparent before calling this.
+func ValidateNetworkMode(mode NetworkMode) error {
+	for _, valid := range ValidNetworkModes {
+		if mode == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported HNS network mode %q, must be one of %v", mode, ValidNetworkModes)
+}
@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hyperv_extension"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns/win_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Microsoft/hcsshim"
+)
+
+// HNSNetworkingAdapter implements contrail_networking.Networking on top of
+// this package's real HNS calls, so HNSContrailNetworksRepository and
+// HNSEndpointRepository can be pointed at
+// contrail_networking.NetworkingSimulator in tests without a Windows host.
+type HNSNetworkingAdapter struct{}
+
+func (HNSNetworkingAdapter) CreateNetwork(config *hcsshim.HNSNetwork) (string, error) {
+	return CreateHNSNetwork(config)
+}
+
+func (HNSNetworkingAdapter) DeleteNetwork(hnsID string) error {
+	return DeleteHNSNetwork(hnsID)
+}
+
+func (HNSNetworkingAdapter) GetNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
+	return GetHNSNetworkByName(name)
+}
+
+func (HNSNetworkingAdapter) ListNetworks() ([]hcsshim.HNSNetwork, error) {
+	return ListHNSNetworks()
+}
+
+func (HNSNetworkingAdapter) CreateEndpoint(config *hcsshim.HNSEndpoint) (string, error) {
+	return CreateHNSEndpoint(config)
+}
+
+func (HNSNetworkingAdapter) DeleteEndpoint(endpointID string) error {
+	return DeleteHNSEndpoint(endpointID)
+}
+
+func (HNSNetworkingAdapter) GetEndpoint(endpointID string) (*hcsshim.HNSEndpoint, error) {
+	return GetHNSEndpoint(endpointID)
+}
+
+func (HNSNetworkingAdapter) ListEndpoints() ([]hcsshim.HNSEndpoint, error) {
+	return ListHNSEndpoints()
+}
+
+func (HNSNetworkingAdapter) ListEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error) {
+	return ListHNSEndpointsOfNetwork(netID)
+}
+
+func (HNSNetworkingAdapter) WaitForValidIP(adapter common.AdapterName) error {
+	return win_networking.WaitForValidIPReacquisition(adapter)
+}
+
+func (HNSNetworkingAdapter) EnableExtension(vswitchName string) error {
+	return hyperv_extension.NewHyperVvRouterForwardingExtension(vswitchName).Enable()
+}
+
+func (HNSNetworkingAdapter) DisableExtension(vswitchName string) error {
+	return hyperv_extension.NewHyperVvRouterForwardingExtension(vswitchName).Disable()
+}
+
+func (HNSNetworkingAdapter) IsExtensionEnabled(vswitchName string) (bool, error) {
+	return hyperv_extension.NewHyperVvRouterForwardingExtension(vswitchName).IsEnabled()
+}
+
+func (HNSNetworkingAdapter) IsExtensionRunning(vswitchName string) (bool, error) {
+	return hyperv_extension.NewHyperVvRouterForwardingExtension(vswitchName).IsRunning()
+}
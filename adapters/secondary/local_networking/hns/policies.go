@@ -0,0 +1,215 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"encoding/json"
+
+	"github.com/Microsoft/hcsshim"
+	log "github.com/sirupsen/logrus"
+)
+
+// hnsPolicyType mirrors hcsshim's internal PolicyType enum values as used in
+// the Policies JSON array of an HNSEndpoint.
+type hnsPolicyType string
+
+const (
+	natPolicyType         hnsPolicyType = "NAT"
+	elbPolicyType         hnsPolicyType = "ELB"
+	routePolicyType       hnsPolicyType = "ROUTE"
+	vlanPolicyType        hnsPolicyType = "VLAN"
+	vsidPolicyType        hnsPolicyType = "VSID"
+	outboundNatPolicyType hnsPolicyType = "OutBoundNAT"
+	qosPolicyType         hnsPolicyType = "QOS"
+)
+
+type natPolicy struct {
+	Type         hnsPolicyType
+	Protocol     string
+	InternalPort uint16
+	ExternalPort uint16
+}
+
+type elbPolicy struct {
+	Type         hnsPolicyType
+	InternalPort uint16
+	ExternalPort uint16
+	Protocol     string
+	VIPs         []string
+}
+
+type routePolicy struct {
+	Type              hnsPolicyType
+	DestinationPrefix string
+	NextHop           string
+}
+
+type vlanPolicy struct {
+	Type hnsPolicyType
+	VLAN uint
+}
+
+type vsidPolicy struct {
+	Type hnsPolicyType
+	VSID uint
+}
+
+type outboundNatPolicy struct {
+	Type       hnsPolicyType
+	Exceptions []string `json:",omitempty"`
+}
+
+type qosPolicy struct {
+	Type                            hnsPolicyType
+	MaximumOutgoingBandwidthInBytes uint64 `json:",omitempty"`
+	Priority                        uint8  `json:",omitempty"`
+}
+
+// NatPolicy builds a port-forwarding policy that exposes a single
+// endpoint's internalPort on the host as externalPort, for the `docker run
+// -p hostPort:containerPort` case.
+func NatPolicy(protocol string, internalPort, externalPort uint16) (json.RawMessage, error) {
+	return json.Marshal(natPolicy{
+		Type:         natPolicyType,
+		Protocol:     protocol,
+		InternalPort: internalPort,
+		ExternalPort: externalPort,
+	})
+}
+
+// ELBPolicy builds a load-balancing policy that exposes internalPort behind
+// externalIP:externalPort, for Swarm-mode published ports (`docker service
+// create -p`) where many endpoints share one VIP.
+func ELBPolicy(protocol string, internalPort, externalPort uint16, externalIP string) (json.RawMessage, error) {
+	return json.Marshal(elbPolicy{
+		Type:         elbPolicyType,
+		Protocol:     protocol,
+		InternalPort: internalPort,
+		ExternalPort: externalPort,
+		VIPs:         []string{externalIP},
+	})
+}
+
+// RoutePolicy builds a static route that's pushed onto a container's vNIC,
+// for a `--route destination=via` network driver-opt.
+func RoutePolicy(destination, nextHop string) (json.RawMessage, error) {
+	return json.Marshal(routePolicy{
+		Type:              routePolicyType,
+		DestinationPrefix: destination,
+		NextHop:           nextHop,
+	})
+}
+
+// VLANPolicy tags every frame sent on the network/endpoint with an 802.1Q
+// VLAN ID, for deployments where a physical switch segments Contrail
+// virtual-networks by VLAN rather than by HNS network.
+func VLANPolicy(vlanID uint) (json.RawMessage, error) {
+	return json.Marshal(vlanPolicy{Type: vlanPolicyType, VLAN: vlanID})
+}
+
+// VSIDPolicy sets the NVGRE Virtual Subnet ID an l2tunnel network forwards
+// its traffic under, for deployments that terminate the tunnel on a
+// virtualization host rather than a physical VLAN.
+func VSIDPolicy(vsid uint) (json.RawMessage, error) {
+	return json.Marshal(vsidPolicy{Type: vsidPolicyType, VSID: vsid})
+}
+
+// OutboundNATPolicy enables source-NAT for a network's outbound traffic,
+// except for destinations matching exceptions (CIDRs that should instead
+// leave un-NATed, e.g. back onto the Contrail overlay).
+func OutboundNATPolicy(exceptions []string) (json.RawMessage, error) {
+	return json.Marshal(outboundNatPolicy{Type: outboundNatPolicyType, Exceptions: exceptions})
+}
+
+// QOSPolicy caps an endpoint's outgoing bandwidth at maxBandwidthBytes bytes
+// per second (0 leaves it unlimited) and sets its relative scheduling
+// priority.
+func QOSPolicy(maxBandwidthBytes uint64, priority uint8) (json.RawMessage, error) {
+	return json.Marshal(qosPolicy{
+		Type:                            qosPolicyType,
+		MaximumOutgoingBandwidthInBytes: maxBandwidthBytes,
+		Priority:                        priority,
+	})
+}
+
+// AddEndpointPolicies appends policies (built with NatPolicy/ELBPolicy) to
+// the HNS endpoint identified by hnsEndpointID.
+func AddEndpointPolicies(hnsEndpointID string, policies []json.RawMessage) error {
+	endpoint, err := GetHNSEndpoint(hnsEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	endpoint.Policies = append(endpoint.Policies, policies...)
+
+	configBytes, err := json.Marshal(endpoint)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	log.Debugln("Updating HNS endpoint policies:", string(configBytes))
+	if _, err := hcsshim.HNSEndpointRequest("POST", hnsEndpointID, string(configBytes)); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+// RemoveELBPolicies strips every ELBPolicy from the HNS endpoint identified
+// by hnsEndpointID, the inverse of AddEndpointPolicies(ELBPolicy(...)). It is
+// idempotent: an endpoint with no ELB policies is left unchanged.
+func RemoveELBPolicies(hnsEndpointID string) error {
+	return removePolicies(hnsEndpointID, elbPolicyType)
+}
+
+func removePolicies(hnsEndpointID string, policyType hnsPolicyType) error {
+	endpoint, err := GetHNSEndpoint(hnsEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	var kept []json.RawMessage
+	for _, p := range endpoint.Policies {
+		var typed struct{ Type hnsPolicyType }
+		if err := json.Unmarshal(p, &typed); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if typed.Type != policyType {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(endpoint.Policies) {
+		return nil
+	}
+	endpoint.Policies = kept
+
+	configBytes, err := json.Marshal(endpoint)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	log.Debugln("Updating HNS endpoint policies:", string(configBytes))
+	if _, err := hcsshim.HNSEndpointRequest("POST", hnsEndpointID, string(configBytes)); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
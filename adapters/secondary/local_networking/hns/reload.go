@@ -0,0 +1,260 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/Microsoft/hcsshim"
+	log "github.com/sirupsen/logrus"
+)
+
+// endpointSnapshot is the pre-reload record of a single HNS endpoint,
+// captured so ReloadNetwork can recreate it with the same IP/MAC and
+// re-attach it to whatever containers were sharing it.
+type endpointSnapshot struct {
+	OldID            string            `json:"oldId"`
+	IPAddress        string            `json:"ipAddress"`
+	MacAddress       string            `json:"macAddress"`
+	GatewayAddress   string            `json:"gatewayAddress"`
+	Policies         []json.RawMessage `json:"policies"`
+	SharedContainers []string          `json:"sharedContainers,omitempty"`
+}
+
+// networkSnapshot is the on-disk record ReloadNetwork writes before tearing
+// a network down, so a reload that's interrupted mid-flight (e.g. the
+// driver process restarting) leaves behind enough to retry or recover
+// manually, instead of silently losing every endpoint's identity.
+type networkSnapshot struct {
+	Name        string             `json:"name"`
+	Type        string             `json:"type"`
+	AdapterName string             `json:"adapterName"`
+	Subnets     []hcsshim.Subnet   `json:"subnets"`
+	Policies    []json.RawMessage  `json:"policies"`
+	Endpoints   []endpointSnapshot `json:"endpoints"`
+}
+
+// DefaultReloadSnapshotDir is where ReloadNetwork persists its snapshots by
+// default, one JSON file per network being reloaded.
+func DefaultReloadSnapshotDir() string {
+	return filepath.Join(os.Getenv("ProgramData"), "Contrail", "reload")
+}
+
+func snapshotFilePath(dir, netID string) string {
+	return filepath.Join(dir, netID+".json")
+}
+
+// ReloadNetwork rebuilds the HNS network identified by netID from scratch,
+// without losing the IP address, MAC address or policies of any endpoint it
+// currently carries: unlike common.HardResetHNS, which wipes every HNS
+// network and endpoint unconditionally, this only ever touches netID and
+// replaces it with an equivalent one. This mirrors podman's `network
+// reload`/`container restore`, which keep a container's address stable
+// across a network being torn down and recreated out from under it.
+//
+// It proceeds in five steps: list netID's endpoints and snapshot their
+// IP/MAC/policies/attached-container state to snapshotDir; recreate the HNS
+// network with the same name, type, adapter and subnets; recreate each
+// endpoint pinned to its recorded IP/MAC/policies; re-attach every recreated
+// endpoint to the containers it was shared with; and finally remove the
+// snapshot. If a step before the final recreate fails, the snapshot file is
+// left behind in snapshotDir so the reload can be diagnosed or retried.
+//
+// It returns the new network's HNS ID and a map from every endpoint's old
+// HNS ID to its new one, so a caller tracking endpoints by HNS ID (such as
+// HNSEndpointRepository) can update its own bookkeeping.
+func ReloadNetwork(netID, snapshotDir string) (newNetID string, idMapping map[string]string, err error) {
+	netw, err := GetHNSNetwork(netID)
+	if err != nil {
+		log.Errorln(err)
+		return "", nil, err
+	}
+	if netw == nil {
+		err := fmt.Errorf("HNS network %s doesn't exist", netID)
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	endpoints, err := ListHNSEndpointsOfNetwork(netID)
+	if err != nil {
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	snapshot := networkSnapshot{
+		Name:        netw.Name,
+		Type:        netw.Type,
+		AdapterName: netw.NetworkAdapterName,
+		Subnets:     netw.Subnets,
+		Policies:    netw.Policies,
+	}
+	for _, ep := range endpoints {
+		snapshot.Endpoints = append(snapshot.Endpoints, endpointSnapshot{
+			OldID:            ep.Id,
+			IPAddress:        ep.IPAddress.String(),
+			MacAddress:       ep.MacAddress,
+			GatewayAddress:   ep.GatewayAddress,
+			Policies:         ep.Policies,
+			SharedContainers: ep.SharedContainers,
+		})
+	}
+
+	if err := writeSnapshot(snapshotDir, netID, snapshot); err != nil {
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	if err := DeleteHNSNetwork(netID); err != nil {
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	newNetID, err = CreateHNSNetwork(&hcsshim.HNSNetwork{
+		Name:               snapshot.Name,
+		Type:               snapshot.Type,
+		NetworkAdapterName: snapshot.AdapterName,
+		Subnets:            snapshot.Subnets,
+		Policies:           snapshot.Policies,
+	})
+	if err != nil {
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	idMapping = make(map[string]string, len(snapshot.Endpoints))
+	for _, epSnapshot := range snapshot.Endpoints {
+		newID, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			VirtualNetwork: newNetID,
+			IPAddress:      net.ParseIP(epSnapshot.IPAddress),
+			MacAddress:     epSnapshot.MacAddress,
+			GatewayAddress: epSnapshot.GatewayAddress,
+			Policies:       epSnapshot.Policies,
+		})
+		if err != nil {
+			log.Errorln(err)
+			return "", nil, err
+		}
+
+		for _, containerID := range epSnapshot.SharedContainers {
+			if err := hcsshim.HotAttachEndpoint(containerID, newID); err != nil {
+				log.Errorln(err)
+				return "", nil, err
+			}
+		}
+
+		idMapping[epSnapshot.OldID] = newID
+	}
+
+	if err := os.Remove(snapshotFilePath(snapshotDir, netID)); err != nil && !os.IsNotExist(err) {
+		log.Errorln(err)
+		return "", nil, err
+	}
+
+	log.Infof("Reloaded HNS network %s as %s, preserving %d endpoint(s)", netID, newNetID,
+		len(idMapping))
+	return newNetID, idMapping, nil
+}
+
+// ReloadEndpoints re-attaches containerID to every endpoint recorded, in any
+// pending snapshot under snapshotDir, as shared with it, matching the
+// recreated endpoint by its (now-stable) IP address. It lets a single
+// container's attachment be retried without re-running the whole
+// ReloadNetwork, for when step 4 (the automatic re-attach inside
+// ReloadNetwork) needs to be redone for one container, e.g. because that
+// container wasn't running yet when its network was reloaded.
+func ReloadEndpoints(containerID, snapshotDir string) error {
+	paths, err := filepath.Glob(filepath.Join(snapshotDir, "*.json"))
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	endpoints, err := ListHNSEndpoints()
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	var reattached int
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		var snapshot networkSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			log.Errorln(err)
+			return err
+		}
+
+		for _, epSnapshot := range snapshot.Endpoints {
+			if !containsString(epSnapshot.SharedContainers, containerID) {
+				continue
+			}
+			newEp := findEndpointByIP(endpoints, epSnapshot.IPAddress)
+			if newEp == nil {
+				continue
+			}
+			if err := hcsshim.HotAttachEndpoint(containerID, newEp.Id); err != nil {
+				log.Errorln(err)
+				return err
+			}
+			reattached++
+		}
+	}
+
+	if reattached == 0 {
+		log.Warnf("ReloadEndpoints: no reloaded endpoint found for container %s", containerID)
+	}
+	return nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func findEndpointByIP(endpoints []hcsshim.HNSEndpoint, ip string) *hcsshim.HNSEndpoint {
+	for i := range endpoints {
+		if endpoints[i].IPAddress.String() == ip {
+			return &endpoints[i]
+		}
+	}
+	return nil
+}
+
+func writeSnapshot(dir, netID string, snapshot networkSnapshot) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	configBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(snapshotFilePath(dir, netID), configBytes, 0600)
+}
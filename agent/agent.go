@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package agent talks to the local Contrail vRouter agent's REST API to
+// register and deregister container ports, so that vRouter starts (and
+// later stops) forwarding traffic for them.
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	log "github.com/sirupsen/logrus"
+)
+
+// AgentRestAPI implements ports.Agent over the vRouter agent's /port REST API.
+type AgentRestAPI struct {
+	client  *http.Client
+	baseURL *url.URL
+}
+
+// NewAgentRestAPI creates an AgentRestAPI that talks to baseURL (e.g.
+// http://127.0.0.1:9091) using client.
+func NewAgentRestAPI(client *http.Client, baseURL *url.URL) *AgentRestAPI {
+	return &AgentRestAPI{client: client, baseURL: baseURL}
+}
+
+type portRequest struct {
+	UUID        string `json:"id"`
+	InstanceID  string `json:"instance-id"`
+	VnID        string `json:"vn-id"`
+	VmProjectID string `json:"vm-project-id"`
+	IPAddress   string `json:"ip-address"`
+	MacAddress  string `json:"mac-address"`
+	SystemName  string `json:"system-name"`
+}
+
+// AddPort tells vRouter agent about a newly created container port.
+func (a *AgentRestAPI) AddPort(assoc *ports.PortAssociation) error {
+	req := portRequest{
+		UUID:        assoc.VifUUID,
+		InstanceID:  assoc.InstanceID,
+		VnID:        assoc.VnID,
+		VmProjectID: assoc.VmProjectID,
+		IPAddress:   assoc.IPAddress,
+		MacAddress:  assoc.MacAddress,
+		SystemName:  assoc.SystemName,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	resp, err := a.client.Post(a.baseURL.String()+"/port", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("agent rejected port %s: %s", assoc.VifUUID, resp.Status)
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+// DeletePort tells vRouter agent to stop forwarding traffic for vifUUID.
+func (a *AgentRestAPI) DeletePort(vifUUID string) error {
+	req, err := http.NewRequest("DELETE", a.baseURL.String()+"/port/"+vifUUID, nil)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("agent rejected port deletion %s: %s", vifUUID, resp.Status)
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
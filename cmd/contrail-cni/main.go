@@ -0,0 +1,41 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// contrail-cni is a CNI ADD/DEL/CHECK plugin binary that drives the same
+// driver_core business logic as the Docker libnetwork driver (this
+// repository's root binary), so it can run standalone or be chained behind
+// another CNI plugin under Kubernetes/Multus. See adapters/primary/cni for
+// the command implementations and NetConf shape.
+package main
+
+import (
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/cni"
+	"github.com/Juniper/contrail-windows-docker-driver/logging"
+	"github.com/containernetworking/cni/pkg/skel"
+	cniVersion "github.com/containernetworking/cni/pkg/version"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	logHook, err := logging.SetupHook(logging.DefaultLogFilepath(), "Info")
+	if err != nil {
+		log.Errorf("Setting up logging failed: %s", err)
+	} else {
+		defer logHook.Close()
+	}
+
+	skel.PluginMain(cni.CmdAdd, cni.CmdCheck, cni.CmdDel, cniVersion.All,
+		"contrail-cni, a CNI frontend to the Contrail Windows docker driver")
+}
@@ -68,6 +68,13 @@ const (
 
 	// HyperVExtensionName is the name of vRouter Hyper-V Extension
 	HyperVExtensionName = "vRouter forwarding extension"
+
+	// L2BridgeMacPoolStart/L2BridgeMacPoolEnd bound the MAC pool handed to
+	// l2bridge HNS networks, which (unlike transparent networks) don't
+	// inherit a MAC range from the physical adapter and need one assigned
+	// explicitly.
+	L2BridgeMacPoolStart = "00-15-5D-52-C0-00"
+	L2BridgeMacPoolEnd   = "00-15-5D-52-CF-FF"
 )
 
 // PluginSpecDir returns path to directory where docker daemon looks for plugin spec files.
@@ -16,12 +16,16 @@
 package configuration
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/Juniper/contrail-windows-docker-driver/logging"
 
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest/auth"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns/win_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	log "github.com/sirupsen/logrus"
 )
 
 type DriverConf struct {
@@ -30,6 +34,23 @@ type DriverConf struct {
 	ControllerPort int
 	AgentURL       string
 	VSwitchName    string
+	// NetworkMode is the default Windows HNS network mode ("transparent",
+	// "l2bridge", "l2tunnel", "nat" or "overlay") for networks that don't
+	// override it with the "network_mode" driver-opt.
+	NetworkMode string
+	// IPAMBackend selects which ports.IPAM implementation CreateNetwork/
+	// CreateEndpoint allocate addresses through: "contrail" (default,
+	// delegates to the Contrail controller as before) or "local" (a bitmap
+	// allocator persisted under ProgramData, supporting "ip_range"/
+	// "exclude_addresses" driver-opts).
+	IPAMBackend string
+	// HNSVersion selects which Windows networking API backs docker networks/
+	// endpoints: "1" for the legacy HNS HTTP-over-RPC shim, or "2" for the
+	// newer HCN v2 API. NewDefaultConfiguration sets it by OS build (see
+	// defaultHNSVersion), so it only needs overriding to pin a host to the
+	// older API (e.g. to keep HNS's reconcile support, which HCN doesn't
+	// have yet).
+	HNSVersion string
 }
 
 type AuthConf struct {
@@ -54,6 +75,9 @@ func NewDefaultConfiguration() (conf Configuration) {
 	conf.Driver.ControllerPort = 8082
 	conf.Driver.AgentURL = "http://127.0.0.1:9091"
 	conf.Driver.VSwitchName = "Layered?<adapter>"
+	conf.Driver.NetworkMode = "transparent"
+	conf.Driver.IPAMBackend = "contrail"
+	conf.Driver.HNSVersion = defaultHNSVersion()
 
 	conf.Logging.LogPath = logging.DefaultLogFilepath()
 	conf.Logging.LogLevel = "Debug"
@@ -72,3 +96,47 @@ func NewDefaultConfiguration() (conf Configuration) {
 func DefaultConfigFilepath() string {
 	return string(filepath.Join(os.Getenv("ProgramData"), "Contrail", "etc", "contrail", "contrail-cnm-plugin.conf"))
 }
+
+// Validate checks that conf is internally consistent and that its settings
+// can actually be acted on (the adapter it names exists, its log level
+// parses, its auth settings are complete), run both at startup and on every
+// reload a Watcher picks up, so a bad edit to the config file never
+// replaces a working configuration with a broken one.
+func (conf *Configuration) Validate() error {
+	if conf.Driver.ControllerPort < 1 || conf.Driver.ControllerPort > 65535 {
+		return fmt.Errorf("ControllerPort %d is out of range 1-65535", conf.Driver.ControllerPort)
+	}
+
+	exists, err := win_networking.AdapterExists(common.AdapterName(conf.Driver.Adapter))
+	if err != nil {
+		return fmt.Errorf("checking adapter %q: %v", conf.Driver.Adapter, err)
+	}
+	if !exists {
+		return fmt.Errorf("adapter %q doesn't exist", conf.Driver.Adapter)
+	}
+
+	switch conf.Driver.HNSVersion {
+	case "1", "2":
+	default:
+		return fmt.Errorf(`HNSVersion %q must be "1" or "2"`, conf.Driver.HNSVersion)
+	}
+
+	switch conf.Auth.AuthMethod {
+	case "noauth":
+	case "keystone":
+		k := conf.Auth.Keystone
+		if k.Os_auth_url == "" || k.Os_username == "" || k.Os_tenant_name == "" ||
+			(k.Os_password == "" && k.Os_token == "") {
+			return fmt.Errorf("AuthMethod \"keystone\" requires os_auth_url, os_username, " +
+				"os_tenant_name and either os_password or os_token to be set")
+		}
+	default:
+		return fmt.Errorf(`AuthMethod %q must be "noauth" or "keystone"`, conf.Auth.AuthMethod)
+	}
+
+	if _, err := log.ParseLevel(conf.Logging.LogLevel); err != nil {
+		return fmt.Errorf("LogLevel %q: %v", conf.Logging.LogLevel, err)
+	}
+
+	return nil
+}
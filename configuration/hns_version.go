@@ -0,0 +1,34 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import "golang.org/x/sys/windows"
+
+// hcnMinBuildNumber is the first Windows build that shipped the HCN v2 API
+// (Windows Server 2019 / Windows 10 1809). Hosts older than this only have
+// the legacy HNS HTTP-over-RPC shim available.
+const hcnMinBuildNumber = 17763
+
+// defaultHNSVersion picks "2" (HCN) on hosts whose build is new enough to
+// support the HCN v2 API, and "1" (legacy HNS) otherwise, so upgrading the
+// host to a release that supports HCN doesn't require an operator to also
+// remember to flip the HNSVersion/networkBackend setting.
+func defaultHNSVersion() string {
+	if windows.RtlGetVersion().BuildNumber >= hcnMinBuildNumber {
+		return "2"
+	}
+	return "1"
+}
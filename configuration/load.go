@@ -0,0 +1,38 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/gcfg.v1"
+)
+
+// Load reads path as an INI-style config file (the same format
+// DefaultConfigFilepath points at) into a copy of NewDefaultConfiguration,
+// so any section/key the file omits keeps its default value, validates the
+// result and returns it.
+func Load(path string) (Configuration, error) {
+	conf := NewDefaultConfiguration()
+	if err := gcfg.ReadFileInto(&conf, path); err != nil {
+		return Configuration{}, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return Configuration{}, err
+	}
+	return conf, nil
+}
@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// debounceDelay absorbs the burst of filesystem events a single config file
+// save tends to produce (e.g. a WRITE followed by a CHMOD, or an editor
+// replacing the file via rename), so Watcher reloads once per save instead
+// of once per event, and never while the file is still being written.
+const debounceDelay = 100 * time.Millisecond
+
+// Watcher observes a configuration file on disk with fsnotify and re-parses
+// it whenever it changes, publishing every successfully validated
+// Configuration over Updates(). A reload that fails to parse or fails
+// Validate is logged and dropped, so Current always holds the last
+// configuration known to be good.
+type Watcher struct {
+	path string
+
+	fsWatcher *fsnotify.Watcher
+	updates   chan *Configuration
+	done      chan struct{}
+
+	mu      sync.RWMutex
+	current Configuration
+}
+
+// NewWatcher loads path once synchronously, so callers either get a usable
+// Configuration immediately or an error they can fail startup on, then
+// starts watching path for changes in the background.
+func NewWatcher(path string) (*Watcher, error) {
+	conf, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the containing directory rather than the file itself: editors
+	// and config-management tools commonly replace a file by renaming a
+	// temp file over it, which fsnotify only reports as an event on the
+	// directory, not on the (now different inode) file.
+	if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	w := &Watcher{
+		path:      path,
+		fsWatcher: fsWatcher,
+		updates:   make(chan *Configuration, 1),
+		done:      make(chan struct{}),
+		current:   conf,
+	}
+	go w.run()
+	return w, nil
+}
+
+// Updates returns the channel every successfully reloaded Configuration is
+// published on. It is never closed while the Watcher is running, and it's
+// the caller's responsibility to keep draining it.
+func (w *Watcher) Updates() <-chan *Configuration {
+	return w.updates
+}
+
+// Current returns the last successfully loaded Configuration.
+func (w *Watcher) Current() Configuration {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Close stops watching the config file. It is safe to call more than once.
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	return w.fsWatcher.Close()
+}
+
+func (w *Watcher) run() {
+	base := filepath.Base(w.path)
+
+	var debounce *time.Timer
+	stopDebounce := func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}
+	defer stopDebounce()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorln("configuration watcher:", err)
+
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != base {
+				continue
+			}
+			stopDebounce()
+			debounce = time.AfterFunc(debounceDelay, w.reload)
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	conf, err := Load(w.path)
+	if err != nil {
+		log.Errorf("reloading configuration from %s failed, keeping previous configuration: %v",
+			w.path, err)
+		return
+	}
+
+	w.mu.Lock()
+	w.current = conf
+	w.mu.Unlock()
+
+	log.Infof("reloaded configuration from %s", w.path)
+	select {
+	case w.updates <- &conf:
+	default:
+		log.Warnln("configuration update channel is full, dropping notification")
+	}
+}
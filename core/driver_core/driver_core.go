@@ -0,0 +1,636 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package driver_core holds the business logic behind the docker libnetwork
+// CNM driver, decoupled from the transport (named pipe / JSON-RPC) it's
+// served over. It only talks to its dependencies through the ports package,
+// so it can be unit tested against fakes.
+package driver_core
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scope selects whether the driven networks are local to this host, or
+// shared cluster-wide (Docker Swarm "global" scope).
+type Scope string
+
+const (
+	// LocalScope is the default: every host manages its own networks, with
+	// no cross-host coordination.
+	LocalScope Scope = "local"
+	// GlobalScope tells libnetwork/Swarm that the Contrail virtual-network
+	// is the same object seen from every host, so CreateNetwork/DeleteNetwork
+	// must be idempotent and reference-counted rather than host-local.
+	GlobalScope Scope = "global"
+)
+
+// ContrailDriverCore implements the network-management logic shared by the
+// docker CNM NetworkDriver and IpamDriver frontends.
+type ContrailDriverCore struct {
+	vrouter  ports.VRouter
+	agent    ports.Agent
+	ipam     ports.IPAM
+	netRepo  ports.LocalContrailNetworkRepository
+	epRepo   ports.EndpointRepository
+	scope    Scope
+	hostname string
+
+	// controllerMu guards controller, which SetController replaces at
+	// runtime (e.g. when the driver's configuration is hot-reloaded and
+	// Auth.AuthMethod changes), while request handling goroutines are
+	// reading it concurrently.
+	controllerMu sync.RWMutex
+	controller   ports.Controller
+}
+
+// NewContrailDriverCore wires up ContrailDriverCore and makes sure the local
+// vRouter forwarding extension is enabled before any network requests can
+// come in. Networks are host-local (LocalScope); use WithScope to opt into
+// Swarm-wide GlobalScope.
+func NewContrailDriverCore(vr ports.VRouter, c ports.Controller, a ports.Agent, ipam ports.IPAM,
+	netRepo ports.LocalContrailNetworkRepository,
+	epRepo ports.EndpointRepository) (*ContrailDriverCore, error) {
+
+	if err := vr.Enable(); err != nil {
+		err = &VRouterError{Op: "Enable", Err: err}
+		log.Errorln(err)
+		return nil, err
+	}
+
+	return &ContrailDriverCore{
+		vrouter:    vr,
+		controller: c,
+		agent:      a,
+		ipam:       ipam,
+		netRepo:    netRepo,
+		epRepo:     epRepo,
+		scope:      LocalScope,
+	}, nil
+}
+
+// WithScope sets the scope networks are created with, and returns core for
+// chaining.
+func (core *ContrailDriverCore) WithScope(scope Scope) *ContrailDriverCore {
+	core.scope = scope
+	return core
+}
+
+// Scope returns the scope this core was configured with.
+func (core *ContrailDriverCore) Scope() Scope {
+	return core.scope
+}
+
+// SetController replaces the Controller core talks to, so a configuration
+// hot-reload can tear down and rebuild it (e.g. switching Auth.AuthMethod
+// between "noauth" and "keystone") without restarting the service. It's
+// safe to call while requests are in flight against the old controller.
+func (core *ContrailDriverCore) SetController(c ports.Controller) {
+	core.controllerMu.Lock()
+	defer core.controllerMu.Unlock()
+	core.controller = c
+}
+
+func (core *ContrailDriverCore) getController() ports.Controller {
+	core.controllerMu.RLock()
+	defer core.controllerMu.RUnlock()
+	return core.controller
+}
+
+// WithHostname sets the identifier this host publishes into Contrail for
+// endpoints it creates in GlobalScope, and returns core for chaining. It
+// defaults to "" (no publishing) until set.
+func (core *ContrailDriverCore) WithHostname(hostname string) *ContrailDriverCore {
+	core.hostname = hostname
+	return core
+}
+
+// Hostname returns the identifier this host was configured with via
+// WithHostname.
+func (core *ContrailDriverCore) Hostname() string {
+	return core.hostname
+}
+
+// CreateNetworkOptions carries the docker-opts CreateNetwork accepts, beyond
+// the tenant/network pair that selects the Contrail virtual-network.
+type CreateNetworkOptions struct {
+	// Subnet picks which of the VN's NetworkIpamRefs to bind to, by CIDR.
+	// Left empty, the VN's first subnet is used.
+	Subnet string
+	// Routes are pushed as route policies onto every endpoint created on
+	// this network.
+	Routes []ports.RouteOption
+	// AuxAddresses are reserved as secondary InstanceIps up front, so
+	// CreateEndpoint never hands them out to a container.
+	AuxAddresses map[string]string
+	// Labels are copied verbatim into the Contrail VN's id_perms annotations.
+	Labels map[string]string
+	// NetworkMode selects which Windows HNS network driver backs this
+	// network: "transparent" (the default), "l2bridge", "l2tunnel", "nat", or
+	// "overlay". Validated by the local network repository, since only it
+	// knows which modes its backend supports.
+	NetworkMode string
+	// VLAN, VSID, MacPools and OutboundNAT are passed straight through to
+	// the local network repository; see ports.LocalNetwork for their
+	// semantics.
+	VLAN        uint
+	VSID        uint
+	MacPools    []ports.MacPoolRange
+	OutboundNAT *ports.OutboundNATOptions
+	// IPRange restricts local IP allocation to a sub-range of the subnet,
+	// given as "<start>-<end>". Only honored by the "local" IPAM backend.
+	IPRange string
+	// Exclude withholds individual addresses, or "<start>-<end>" sub-ranges,
+	// from allocation within IPRange. Only honored by the "local" IPAM
+	// backend.
+	Exclude []string
+}
+
+// CreateEndpointOptions carries the docker-opts CreateEndpoint accepts,
+// beyond the preferred IP address negotiated by libnetwork.
+type CreateEndpointOptions struct {
+	// QoS caps this endpoint's outgoing bandwidth and sets its scheduling
+	// priority, if set.
+	QoS *ports.QosOptions
+	// DNS is the DNS server list, suffix and search list applied to this
+	// endpoint's vNIC, if set.
+	DNS *ports.DNSOptions
+}
+
+// CreateNetwork looks up the Contrail virtual-network identified by
+// tenant/network and registers a corresponding local (HNS) network for
+// dockerNetID. In GlobalScope, the Contrail VN is shared cluster-wide:
+// netRepo is expected to treat a VN that's already backed by an HNS network
+// (created by an earlier CreateNetwork call, possibly for a different
+// dockerNetID on another host) as a no-op and just bump its reference count.
+func (core *ContrailDriverCore) CreateNetwork(dockerNetID, tenant, network string,
+	opts CreateNetworkOptions) (*ports.LocalNetwork, error) {
+
+	if tenant == "" || network == "" {
+		err := fmt.Errorf("tenant and network options are required")
+		log.Errorln(err)
+		return nil, err
+	}
+
+	contrailNet, err := core.getController().GetNetwork(tenant, network)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if contrailNet == nil {
+		err := fmt.Errorf("Contrail virtual-network %s:%s doesn't exist", tenant, network)
+		log.Errorln(err)
+		return nil, err
+	}
+
+	subnet, gateway, err := selectSubnet(contrailNet, opts.Subnet)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	if len(opts.Labels) > 0 {
+		if err := core.getController().SetNetworkLabels(contrailNet, opts.Labels); err != nil {
+			log.Errorln(err)
+			return nil, err
+		}
+	}
+
+	exclude := opts.Exclude
+	for name, addr := range opts.AuxAddresses {
+		if _, err := core.getController().AllocateInstanceIp(contrailNet, name, addr, true); err != nil {
+			log.Errorln(err)
+			return nil, err
+		}
+		// Reserved the same as any other aux address, so an IPAM backend
+		// that doesn't share Contrail's bookkeeping (e.g. local_ipam's
+		// bitmap allocator) doesn't hand addr back out to a container and
+		// collide with the secondary InstanceIp just allocated for it.
+		exclude = append(exclude, addr)
+	}
+
+	poolID, err := core.ipam.RequestPool(tenant, network, subnet, ports.IPAMPoolOptions{
+		IPRange: opts.IPRange,
+		Exclude: exclude,
+	})
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	localNet := &ports.LocalNetwork{
+		ID:              dockerNetID,
+		ContrailNetUUID: contrailNet.GetUuid(),
+		Tenant:          tenant,
+		Network:         network,
+		Subnet:          subnet,
+		Gateway:         gateway,
+		Routes:          opts.Routes,
+		NetworkMode:     opts.NetworkMode,
+		VLAN:            opts.VLAN,
+		VSID:            opts.VSID,
+		MacPools:        opts.MacPools,
+		OutboundNAT:     opts.OutboundNAT,
+		PoolID:          poolID,
+	}
+	if err := core.netRepo.AddNetwork(localNet); err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	log.Infof("Created network %s for Contrail VN %s:%s (scope: %s)", dockerNetID, tenant,
+		network, core.scope)
+	return localNet, nil
+}
+
+// DeleteNetwork tears down the local network registered for dockerNetID. It
+// never looks up the Contrail VN, so a VN the operator removed out-of-band
+// is not an error; a dockerNetID netRepo doesn't know (including one already
+// deleted) is. netRepo only removes the underlying HNS network once its last
+// reference is gone.
+func (core *ContrailDriverCore) DeleteNetwork(dockerNetID string) error {
+	localNet, err := core.netRepo.GetNetwork(dockerNetID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	if err := core.netRepo.DeleteNetwork(dockerNetID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	if localNet != nil {
+		if err := core.ipam.ReleasePool(localNet.PoolID); err != nil {
+			log.Errorln(err)
+			return err
+		}
+	}
+
+	log.Infof("Deleted network %s", dockerNetID)
+	return nil
+}
+
+// GetNetwork returns the local network registered for dockerNetID.
+func (core *ContrailDriverCore) GetNetwork(dockerNetID string) (*ports.LocalNetwork, error) {
+	return core.netRepo.GetNetwork(dockerNetID)
+}
+
+// CreateEndpoint allocates an instance-IP in Contrail for the given network
+// and registers a local (HNS) endpoint for it.
+func (core *ContrailDriverCore) CreateEndpoint(dockerNetID, dockerEndpointID,
+	preferredIP string, opts CreateEndpointOptions) (*ports.LocalEndpoint, error) {
+
+	localNet, err := core.netRepo.GetNetwork(dockerNetID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if localNet == nil {
+		err := &NetworkNotFoundError{ID: dockerNetID}
+		log.Errorln(err)
+		return nil, err
+	}
+
+	contrailNet, err := core.getController().GetNetwork(localNet.Tenant, localNet.Network)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if contrailNet == nil {
+		err := fmt.Errorf("Contrail virtual-network backing %s is gone", dockerNetID)
+		log.Errorln(err)
+		return nil, err
+	}
+
+	_, gw, err := selectSubnet(contrailNet, localNet.Subnet)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	_, subnetNet, err := net.ParseCIDR(localNet.Subnet)
+	if err != nil {
+		err = fmt.Errorf("network %s has invalid subnet %q: %v", dockerNetID, localNet.Subnet, err)
+		log.Errorln(err)
+		return nil, err
+	}
+	prefixLen, _ := subnetNet.Mask.Size()
+
+	address, err := core.ipam.RequestAddress(localNet.PoolID, dockerEndpointID,
+		ports.IPAMAddressOptions{Address: preferredIP})
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	instanceIP, err := core.getController().AllocateInstanceIp(contrailNet, dockerEndpointID,
+		address, false)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	if core.scope == GlobalScope && core.hostname != "" {
+		// Publish which host owns this endpoint into Contrail itself, so any
+		// other host sharing this virtual-network can discover it without
+		// standing up a separate distributed store.
+		if err := core.getController().AnnotateInstanceIp(instanceIP, map[string]string{
+			"docker-endpoint-id":   dockerEndpointID,
+			"docker-endpoint-host": core.hostname,
+		}); err != nil {
+			log.Errorln(err)
+			return nil, err
+		}
+	}
+
+	if opts.DNS != nil {
+		// Mirror the endpoint's DNS configuration into Contrail, so the
+		// vRouter agent hands out the same servers/suffix/search list over
+		// DHCP that HNS applies locally to the endpoint's vNIC.
+		if err := core.getController().SetInstanceIpDNS(instanceIP, *opts.DNS); err != nil {
+			log.Errorln(err)
+			return nil, err
+		}
+	}
+
+	localEp := &ports.LocalEndpoint{
+		ID:              dockerEndpointID,
+		DockerNetID:     dockerNetID,
+		HNSNetworkID:    localNet.HNSID,
+		ContrailVMIUUID: instanceIP.GetUuid(),
+		IPAddress:       instanceIP.GetInstanceIpAddress(),
+		PrefixLen:       prefixLen,
+		Gateway:         gw,
+		Routes:          localNet.Routes,
+		Host:            core.hostname,
+		QoS:             opts.QoS,
+		DNS:             opts.DNS,
+	}
+	if err := core.epRepo.AddEndpoint(localEp); err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	if err := core.agent.AddPort(&ports.PortAssociation{
+		VifUUID:    localEp.ContrailVMIUUID,
+		InstanceID: dockerEndpointID,
+		VnID:       contrailNet.GetUuid(),
+		IPAddress:  localEp.IPAddress,
+		MacAddress: localEp.MacAddress,
+		SystemName: dockerEndpointID,
+	}); err != nil {
+		err = &AgentError{Op: "AddPort", Err: err}
+		log.Errorln(err)
+		return nil, err
+	}
+
+	return localEp, nil
+}
+
+// GetEndpoint returns the local endpoint registered for dockerEndpointID.
+func (core *ContrailDriverCore) GetEndpoint(dockerEndpointID string) (*ports.LocalEndpoint, error) {
+	return core.epRepo.GetEndpoint(dockerEndpointID)
+}
+
+// GetGlobalEndpoint resolves dockerEndpointID the same way GetEndpoint does,
+// but in GlobalScope also falls back to Contrail's docker-endpoint-host
+// annotation (written by CreateEndpoint) when this host's own epRepo has no
+// record of it — either because the endpoint was created on a different
+// host, or because a restart lost this host's in-memory state. The returned
+// endpoint's Host field is the one callers should compare against Hostname()
+// before acting on its vNIC: Join/Leave can't do anything useful with an
+// endpoint that lives on another host, but EndpointOperInfo can still report
+// it. Returns nil, nil if Contrail has no record of it either.
+func (core *ContrailDriverCore) GetGlobalEndpoint(dockerNetID,
+	dockerEndpointID string) (*ports.LocalEndpoint, error) {
+
+	ep, err := core.epRepo.GetEndpoint(dockerEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if ep != nil || core.scope != GlobalScope {
+		return ep, nil
+	}
+
+	localNet, err := core.netRepo.GetNetwork(dockerNetID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if localNet == nil {
+		return nil, nil
+	}
+
+	contrailNet, err := core.getController().GetNetwork(localNet.Tenant, localNet.Network)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if contrailNet == nil {
+		return nil, nil
+	}
+
+	instanceIP, annotations, err := core.getController().FindInstanceIp(contrailNet, dockerEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	if instanceIP == nil {
+		return nil, nil
+	}
+
+	return &ports.LocalEndpoint{
+		ID:              dockerEndpointID,
+		DockerNetID:     dockerNetID,
+		ContrailVMIUUID: instanceIP.GetUuid(),
+		IPAddress:       instanceIP.GetInstanceIpAddress(),
+		Gateway:         localNet.Gateway,
+		Host:            annotations["docker-endpoint-host"],
+	}, nil
+}
+
+// DeleteEndpoint releases the instance-IP allocated for dockerEndpointID and
+// forgets the local endpoint.
+func (core *ContrailDriverCore) DeleteEndpoint(dockerEndpointID string) error {
+	localEp, err := core.epRepo.GetEndpoint(dockerEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if localEp == nil {
+		err := &EndpointNotFoundError{ID: dockerEndpointID}
+		log.Errorln(err)
+		return err
+	}
+
+	// A DeletePort failure doesn't stop the rest of the teardown; its
+	// AgentError is returned once everything else has been released.
+	var agentErr error
+	if err := core.agent.DeletePort(localEp.ContrailVMIUUID); err != nil {
+		agentErr = &AgentError{Op: "DeletePort", Err: err}
+		log.Errorln(agentErr)
+	}
+
+	if err := core.epRepo.DeleteEndpoint(dockerEndpointID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	localNet, err := core.netRepo.GetNetwork(localEp.DockerNetID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if localNet != nil {
+		if err := core.ipam.ReleaseAddress(localNet.PoolID, localEp.IPAddress); err != nil {
+			log.Errorln(err)
+			return err
+		}
+
+		contrailNet, err := core.getController().GetNetwork(localNet.Tenant, localNet.Network)
+		if err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if contrailNet != nil {
+			// The InstanceIp CreateEndpoint allocated is named after
+			// dockerEndpointID, not localEp.ContrailVMIUUID (that's its
+			// generated UUID); release it by the same name or it's leaked.
+			if err := core.getController().ReleaseInstanceIp(contrailNet, dockerEndpointID); err != nil {
+				log.Errorln(err)
+				return err
+			}
+		}
+	}
+	return agentErr
+}
+
+// ProgramExternalConnectivity allocates a Contrail FloatingIp for
+// dockerEndpointID's VMI and returns its address, so the caller can translate
+// `docker run -p` port bindings into HNS NAT/ELB policies against it.
+func (core *ContrailDriverCore) ProgramExternalConnectivity(dockerEndpointID string) (string, error) {
+	localEp, err := core.epRepo.GetEndpoint(dockerEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	if localEp == nil {
+		err := &EndpointNotFoundError{ID: dockerEndpointID}
+		log.Errorln(err)
+		return "", err
+	}
+	if localEp.FloatingIPAddress != "" {
+		// Already programmed; ProgramExternalConnectivity must be idempotent.
+		return localEp.FloatingIPAddress, nil
+	}
+
+	localNet, err := core.netRepo.GetNetwork(localEp.DockerNetID)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	if localNet == nil {
+		err := &NetworkNotFoundError{ID: localEp.DockerNetID}
+		log.Errorln(err)
+		return "", err
+	}
+
+	fip, err := core.getController().AllocateFloatingIp(localNet.Tenant, localEp.ContrailVMIUUID)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+
+	localEp.FloatingIPUUID = fip.GetUuid()
+	localEp.FloatingIPAddress = fip.GetFloatingIpAddress()
+	if err := core.epRepo.UpdateEndpoint(localEp); err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+
+	return localEp.FloatingIPAddress, nil
+}
+
+// RevokeExternalConnectivity releases the FloatingIp allocated by a prior
+// ProgramExternalConnectivity call for dockerEndpointID. It is a no-op if
+// none was allocated.
+func (core *ContrailDriverCore) RevokeExternalConnectivity(dockerEndpointID string) error {
+	localEp, err := core.epRepo.GetEndpoint(dockerEndpointID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if localEp == nil || localEp.FloatingIPUUID == "" {
+		return nil
+	}
+
+	fip := &types.FloatingIp{}
+	fip.SetUuid(localEp.FloatingIPUUID)
+	if err := core.getController().ReleaseFloatingIp(fip); err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	localEp.FloatingIPUUID = ""
+	localEp.FloatingIPAddress = ""
+	return core.epRepo.UpdateEndpoint(localEp)
+}
+
+func firstSubnet(net *types.VirtualNetwork) (cidr, gateway string, err error) {
+	ipams, err := net.GetNetworkIpamRefs()
+	if err != nil {
+		return "", "", err
+	}
+	if len(ipams) == 0 {
+		return "", "", fmt.Errorf("virtual-network %s has no IPAM refs", net.GetName())
+	}
+	subnets := ipams[0].Attr.(types.VnSubnetsType).IpamSubnets
+	if len(subnets) == 0 {
+		return "", "", fmt.Errorf("virtual-network %s has no subnets", net.GetName())
+	}
+	subnet := subnets[0]
+	cidr = fmt.Sprintf("%s/%d", subnet.SubnetIp, subnet.SubnetPrefixLen)
+	return cidr, subnet.DefaultGateway, nil
+}
+
+// selectSubnet picks the subnet matching wantCIDR among net's
+// NetworkIpamRefs, or net's first subnet if wantCIDR is empty.
+func selectSubnet(net *types.VirtualNetwork, wantCIDR string) (cidr, gateway string, err error) {
+	if wantCIDR == "" {
+		return firstSubnet(net)
+	}
+
+	ipams, err := net.GetNetworkIpamRefs()
+	if err != nil {
+		return "", "", err
+	}
+	for _, ipam := range ipams {
+		for _, subnet := range ipam.Attr.(types.VnSubnetsType).IpamSubnets {
+			candidate := fmt.Sprintf("%s/%d", subnet.SubnetIp, subnet.SubnetPrefixLen)
+			if candidate == wantCIDR {
+				return candidate, subnet.DefaultGateway, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("virtual-network %s has no subnet matching %s", net.GetName(), wantCIDR)
+}
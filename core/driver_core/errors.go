@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver_core
+
+import "fmt"
+
+// AgentError wraps a failure from the local vRouter agent's REST API, so
+// callers can tell a forwarding-plane programming failure apart from a
+// Contrail/HNS one. It implements libnetwork's MaskableError interface
+// (https://github.com/docker/libnetwork/blob/master/types/types.go): a
+// maskable error is logged by libnetwork but doesn't fail the CNM request,
+// which matters for DeletePort, since a container is already gone by the
+// time we try to tell the agent to forget it.
+type AgentError struct {
+	Op  string // "AddPort" or "DeletePort"
+	Err error
+}
+
+func (e *AgentError) Error() string {
+	return fmt.Sprintf("vRouter agent %s failed: %v", e.Op, e.Err)
+}
+
+func (e *AgentError) Unwrap() error {
+	return e.Err
+}
+
+// Maskable reports whether libnetwork may log and ignore this error rather
+// than failing the request. Only DeletePort failures are maskable: the
+// endpoint is being torn down either way.
+func (e *AgentError) Maskable() bool {
+	return e.Op == "DeletePort"
+}
+
+// VRouterError wraps a failure enabling, disabling or querying the local
+// Hyper-V vRouter forwarding extension.
+type VRouterError struct {
+	Op  string // "Enable", "Disable", "IsEnabled" or "IsRunning"
+	Err error
+}
+
+func (e *VRouterError) Error() string {
+	return fmt.Sprintf("vRouter %s failed: %v", e.Op, e.Err)
+}
+
+func (e *VRouterError) Unwrap() error {
+	return e.Err
+}
+
+// NetworkNotFoundError reports that dockerNetID (or the Contrail
+// virtual-network it's supposed to back) no longer exists. It implements
+// libnetwork's NotFoundError interface, but docker_libnetwork_plugin sends it
+// over the plugin protocol like any other non-maskable error: as a plain
+// `{"Err": "..."}` string, without its error class.
+type NetworkNotFoundError struct {
+	ID string // docker network ID
+}
+
+func (e *NetworkNotFoundError) Error() string {
+	return fmt.Sprintf("network %s doesn't exist", e.ID)
+}
+
+// NotFound satisfies libnetwork's NotFoundError interface.
+func (e *NetworkNotFoundError) NotFound() bool {
+	return true
+}
+
+// EndpointNotFoundError reports that dockerEndpointID is unknown to the
+// driver, e.g. because it was never created here or has already been torn
+// down. Like NetworkNotFoundError, docker_libnetwork_plugin sends it as a
+// plain `{"Err": "..."}` string, without its error class.
+type EndpointNotFoundError struct {
+	ID string // docker endpoint ID
+}
+
+func (e *EndpointNotFoundError) Error() string {
+	return fmt.Sprintf("endpoint %s doesn't exist", e.ID)
+}
+
+// NotFound satisfies libnetwork's NotFoundError interface.
+func (e *EndpointNotFoundError) NotFound() bool {
+	return true
+}
+
+// EndpointOwnedByOtherHostError reports that dockerEndpointID exists, per
+// Contrail's GlobalScope bookkeeping, but was created on a different host,
+// so this host has no vNIC to Join/Leave on its behalf.
+type EndpointOwnedByOtherHostError struct {
+	ID   string // docker endpoint ID
+	Host string // the host that actually owns it
+}
+
+func (e *EndpointOwnedByOtherHostError) Error() string {
+	return fmt.Sprintf("endpoint %s is owned by host %s, not this host", e.ID, e.Host)
+}
@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam_core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// AddressPool is one "--default-address-pools" entry: a CIDR IpamCore
+// carves same-size subnets out of for Contrail virtual-networks created
+// without already existing in Contrail, mirroring dockerd's own
+// "--default-address-pool" daemon flag.
+type AddressPool struct {
+	Base *net.IPNet
+	Size int
+}
+
+// DefaultPoolsFlag implements flag.Value, accumulating one AddressPool per
+// occurrence of a repeated "-default-address-pools base=<cidr>,size=<n>"
+// flag, the same way docker's own opts.PoolsOpt does for dockerd.
+type DefaultPoolsFlag []AddressPool
+
+func (f *DefaultPoolsFlag) String() string {
+	parts := make([]string, len(*f))
+	for i, pool := range *f {
+		parts[i] = fmt.Sprintf("base=%s,size=%d", pool.Base, pool.Size)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *DefaultPoolsFlag) Set(value string) error {
+	var base string
+	size := -1
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("malformed default-address-pools entry %q, want base=<cidr>,size=<n>",
+				value)
+		}
+		switch kv[0] {
+		case "base":
+			base = kv[1]
+		case "size":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return fmt.Errorf("malformed default-address-pools size %q: %v", kv[1], err)
+			}
+			size = n
+		default:
+			return fmt.Errorf("unknown default-address-pools field %q", kv[0])
+		}
+	}
+	if base == "" {
+		return fmt.Errorf("default-address-pools entry %q is missing base=<cidr>", value)
+	}
+	_, baseNet, err := net.ParseCIDR(base)
+	if err != nil {
+		return fmt.Errorf("invalid default-address-pools base %q: %v", base, err)
+	}
+	ones, bits := baseNet.Mask.Size()
+	if size == -1 {
+		size = ones
+	}
+	if size < ones || size > bits {
+		return fmt.Errorf("default-address-pools size /%d is outside of base %s", size, baseNet)
+	}
+	*f = append(*f, AddressPool{Base: baseNet, Size: size})
+	return nil
+}
+
+// carveSubnet hands out the next unused subnet across every configured
+// default pool, advancing to the following pool once one is exhausted.
+// Unlike local_ipam's bbolt-backed bitmap allocator, it only tracks state in memory: a
+// restarted driver starts carving from the first pool again, so operators
+// relying on this for anything beyond disposable bring-up VNs should
+// pre-provision their Contrail virtual-networks instead.
+func (core *IpamCore) carveSubnet() (string, error) {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	for core.nextPool < len(core.defaultPools) {
+		pool := core.defaultPools[core.nextPool]
+		base := binary.BigEndian.Uint32(pool.Base.IP.To4())
+		ones, _ := pool.Base.Mask.Size()
+		subnetSize := uint32(1) << uint(32-pool.Size)
+		total := uint32(1) << uint(pool.Size-ones)
+
+		if core.nextSubnet >= total {
+			core.nextPool++
+			core.nextSubnet = 0
+			continue
+		}
+
+		subnetBase := base + core.nextSubnet*subnetSize
+		core.nextSubnet++
+
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, subnetBase)
+		return fmt.Sprintf("%s/%d", ip, pool.Size), nil
+	}
+	return "", fmt.Errorf("every configured default-address-pools subnet is already in use")
+}
@@ -0,0 +1,277 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipam_core holds the business logic behind the docker libnetwork
+// IpamDriver, mirroring driver_core: it only depends on ports.Controller, so
+// it can be unit tested against a fake controller.
+package ipam_core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	log "github.com/sirupsen/logrus"
+)
+
+// IpamCore implements IP pool/address management backed by Contrail.
+type IpamCore struct {
+	// controllerMu guards controller, which SetController replaces at
+	// runtime (e.g. when the driver's configuration is hot-reloaded and
+	// Auth.AuthMethod changes), while request handling goroutines are
+	// reading it concurrently.
+	controllerMu sync.RWMutex
+	controller   ports.Controller
+
+	// defaultPools backs RequestPool's fallback for a tenant/network that
+	// doesn't exist in Contrail yet: instead of failing, a subnet is carved
+	// out of these pools and the virtual-network is created on the fly.
+	// Left empty (the default), every virtual-network must be pre-provisioned.
+	defaultPools []AddressPool
+
+	mu             sync.Mutex
+	nextPool       int
+	nextSubnet     uint32
+	nextInstanceIP uint64
+
+	// instanceIPNames maps "poolID|address" to the InstanceIp name
+	// RequestAddress minted for it, so ReleaseAddress can delete the same
+	// Contrail object it created instead of guessing its name from address.
+	instanceIPNames map[string]string
+}
+
+// NewIpamCore creates an IpamCore backed by c. defaultPools, if non-empty,
+// lets RequestPool create a virtual-network that doesn't already exist in
+// Contrail, carving its subnet out of the pools instead of requiring one to
+// be pre-provisioned.
+func NewIpamCore(c ports.Controller, defaultPools []AddressPool) *IpamCore {
+	return &IpamCore{
+		controller:      c,
+		defaultPools:    defaultPools,
+		instanceIPNames: make(map[string]string),
+	}
+}
+
+// Pool identifies a Contrail virtual-network's subnet. It round-trips
+// through libnetwork as the opaque PoolID string.
+type Pool struct {
+	Tenant  string
+	Network string
+}
+
+func (p Pool) String() string {
+	return p.Tenant + ":" + p.Network
+}
+
+// SetController replaces the Controller core talks to, so a configuration
+// hot-reload can tear down and rebuild it (e.g. switching Auth.AuthMethod
+// between "noauth" and "keystone") without restarting the service. It's
+// safe to call while requests are in flight against the old controller.
+func (core *IpamCore) SetController(c ports.Controller) {
+	core.controllerMu.Lock()
+	defer core.controllerMu.Unlock()
+	core.controller = c
+}
+
+func (core *IpamCore) getController() ports.Controller {
+	core.controllerMu.RLock()
+	defer core.controllerMu.RUnlock()
+	return core.controller
+}
+
+func parsePool(poolID string) (Pool, error) {
+	parts := strings.SplitN(poolID, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Pool{}, fmt.Errorf("malformed pool ID: %s", poolID)
+	}
+	return Pool{Tenant: parts[0], Network: parts[1]}, nil
+}
+
+// RequestPool looks up the Contrail virtual-network referenced by
+// tenant/network and returns its subnet CIDR and gateway. Docker calls this
+// once per `docker network create`, before RequestAddress.
+//
+// If the virtual-network doesn't exist yet and defaultPools was configured,
+// RequestPool creates it instead of failing: subnet, if the admin passed an
+// explicit `--subnet`, pins the carved virtual-network to it; left empty,
+// the next unused subnet is carved out of defaultPools. This is what lets
+// `docker network create -d Contrail --ipam-driver=Contrail netname` work
+// without a pre-provisioned Contrail virtual-network.
+func (core *IpamCore) RequestPool(tenant, network, subnet string) (poolID, cidr, gateway string, err error) {
+	if tenant == "" || network == "" {
+		err = fmt.Errorf("tenant and network ipam-opts are required")
+		log.Errorln(err)
+		return
+	}
+
+	contrailNet, err := core.getController().GetNetwork(tenant, network)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	if contrailNet == nil {
+		if len(core.defaultPools) == 0 {
+			err = fmt.Errorf("Contrail virtual-network %s:%s doesn't exist", tenant, network)
+			log.Errorln(err)
+			return
+		}
+
+		if subnet == "" {
+			subnet, err = core.carveSubnet()
+			if err != nil {
+				log.Errorln(err)
+				return
+			}
+		}
+
+		contrailNet, err = core.getController().CreateNetworkWithSubnet(tenant, network, subnet)
+		if err != nil {
+			log.Errorln(err)
+			return
+		}
+		log.Infof("Auto-created Contrail virtual-network %s:%s with subnet %s from "+
+			"default-address-pools", tenant, network, subnet)
+	}
+
+	cidr, gateway, err = firstSubnet(contrailNet)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+
+	pool := Pool{Tenant: tenant, Network: network}
+	return pool.String(), cidr, gateway, nil
+}
+
+// ReleasePool is a no-op: the Contrail virtual-network is owned by whoever
+// created it in Contrail, not by this IPAM driver.
+func (core *IpamCore) ReleasePool(poolID string) error {
+	if _, err := parsePool(poolID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+// RequestAddress allocates a real Contrail InstanceIp in the virtual-network
+// identified by poolID, optionally pinned to preferredAddress, and returns it
+// in CIDR form: libnetwork's remote IpamDriver protocol both passes and
+// expects back addresses as "ip/prefixlen", parsing the response with
+// types.ParseCIDR, and rejects a bare IP.
+func (core *IpamCore) RequestAddress(poolID, preferredAddress string) (string, error) {
+	pool, err := parsePool(poolID)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+
+	contrailNet, err := core.getController().GetNetwork(pool.Tenant, pool.Network)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	if contrailNet == nil {
+		err := fmt.Errorf("Contrail virtual-network %s doesn't exist", pool)
+		log.Errorln(err)
+		return "", err
+	}
+
+	cidr, _, err := firstSubnet(contrailNet)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+	prefixLen := strings.SplitN(cidr, "/", 2)[1]
+
+	// preferredAddress, if set, arrives in the same CIDR form; Contrail's
+	// AllocateInstanceIp wants the bare IP.
+	preferredIP := strings.SplitN(preferredAddress, "/", 2)[0]
+
+	// Every InstanceIp in this pool needs its own name: poolID alone is
+	// shared by every address request against it, and AllocateInstanceIp
+	// fails if the name collides with one already in Contrail. The VMI the
+	// InstanceIp eventually attaches to is created later, in CreateEndpoint.
+	core.mu.Lock()
+	core.nextInstanceIP++
+	name := fmt.Sprintf("%s-%d", poolID, core.nextInstanceIP)
+	core.mu.Unlock()
+
+	instanceIP, err := core.getController().AllocateInstanceIp(contrailNet, name, preferredIP, false)
+	if err != nil {
+		log.Errorln(err)
+		return "", err
+	}
+
+	address := instanceIP.GetInstanceIpAddress() + "/" + prefixLen
+	core.mu.Lock()
+	core.instanceIPNames[poolID+"|"+address] = name
+	core.mu.Unlock()
+
+	return address, nil
+}
+
+// ReleaseAddress deletes the InstanceIp RequestAddress allocated for address.
+func (core *IpamCore) ReleaseAddress(poolID, address string) error {
+	pool, err := parsePool(poolID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	contrailNet, err := core.getController().GetNetwork(pool.Tenant, pool.Network)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if contrailNet == nil {
+		// Network is already gone: nothing left to release.
+		return nil
+	}
+
+	key := poolID + "|" + address
+	core.mu.Lock()
+	name, ok := core.instanceIPNames[key]
+	if ok {
+		delete(core.instanceIPNames, key)
+	}
+	core.mu.Unlock()
+	if !ok {
+		err := fmt.Errorf("no InstanceIp on record for %s in pool %s", address, poolID)
+		log.Errorln(err)
+		return err
+	}
+
+	return core.getController().ReleaseInstanceIp(contrailNet, name)
+}
+
+func firstSubnet(net *types.VirtualNetwork) (cidr, gateway string, err error) {
+	ipams, err := net.GetNetworkIpamRefs()
+	if err != nil {
+		return "", "", err
+	}
+	if len(ipams) == 0 {
+		return "", "", fmt.Errorf("virtual-network %s has no IPAM refs", net.GetName())
+	}
+	subnets := ipams[0].Attr.(types.VnSubnetsType).IpamSubnets
+	if len(subnets) == 0 {
+		return "", "", fmt.Errorf("virtual-network %s has no subnets", net.GetName())
+	}
+	subnet := subnets[0]
+	cidr = fmt.Sprintf("%s/%d", subnet.SubnetIp, subnet.SubnetPrefixLen)
+	gateway = fmt.Sprintf("%s/%d", subnet.DefaultGateway, subnet.SubnetPrefixLen)
+	return cidr, gateway, nil
+}
@@ -0,0 +1,282 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ports declares the interfaces that core business logic (driver_core,
+// ipam_core) depends on. Concrete implementations live in adapters/secondary
+// and adapters/primary/*/testing, so that core can be unit tested against
+// fakes without touching vRouter, HNS or the real Contrail controller.
+package ports
+
+import (
+	contrail "github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/types"
+)
+
+// VRouter abstracts the local Hyper-V vRouter forwarding extension, so that
+// driver_core doesn't need to know about PowerShell or HNS switches.
+type VRouter interface {
+	Enable() error
+	Disable() error
+	IsEnabled() (bool, error)
+	IsRunning() (bool, error)
+}
+
+// Controller abstracts the calls core makes against the Contrail API server.
+// Implementations wrap either the real contrail-go-api client
+// (controller_rest.ControllerAdapter) or an in-memory fake used by tests.
+type Controller interface {
+	NewProject(domain, tenant string) (*types.Project, error)
+	GetOrCreateProject(domain, tenant string) (*types.Project, error)
+
+	CreateNetworkWithSubnet(tenant, network, subnetCIDR string) (*types.VirtualNetwork, error)
+	GetNetwork(tenant, network string) (*types.VirtualNetwork, error)
+	// GetNetworkByUUID looks up a virtual-network by the UUID embedded in
+	// its HNS network's name, for reconciling HNS state against Contrail
+	// without already knowing the owning tenant/network pair.
+	GetNetworkByUUID(uuid string) (*types.VirtualNetwork, error)
+
+	AllocateInstanceIp(net *types.VirtualNetwork, vmiUUID, preferredIP string,
+		secondary bool) (*types.InstanceIp, error)
+	ReleaseInstanceIp(net *types.VirtualNetwork, instanceIPName string) error
+
+	// AllocateFloatingIp allocates a FloatingIp from tenant's default
+	// floating-ip pool and associates it with the VMI that owns vmiUUID's
+	// instance-IP, so external traffic addressed to the floating IP is
+	// forwarded to it.
+	AllocateFloatingIp(tenant, vmiUUID string) (*types.FloatingIp, error)
+	ReleaseFloatingIp(fip *types.FloatingIp) error
+
+	// SetNetworkLabels copies labels verbatim into net's id_perms annotations
+	// (`com.docker.network.label.*` generic options), and saves it.
+	SetNetworkLabels(net *types.VirtualNetwork, labels map[string]string) error
+
+	// AnnotateInstanceIp copies annotations verbatim into instanceIP's
+	// id_perms annotations and saves it. In GlobalScope, this is how a host
+	// publishes which of its endpoints own a given InstanceIp into Contrail,
+	// so every other host sharing the same virtual-network can discover it
+	// without a separate distributed store.
+	AnnotateInstanceIp(instanceIP *types.InstanceIp, annotations map[string]string) error
+
+	// FindInstanceIp looks up the InstanceIp AllocateInstanceIp created for
+	// vmiUUID under net, along with whatever annotations AnnotateInstanceIp
+	// most recently wrote onto it, or nil/nil if none exists. In GlobalScope,
+	// this is the Contrail-side mirror of EndpointRepository.GetEndpoint: it
+	// lets a host rehydrate an endpoint's metadata (including which host
+	// owns it) when its own in-memory epRepo has no record of it.
+	FindInstanceIp(net *types.VirtualNetwork, vmiUUID string) (instanceIP *types.InstanceIp,
+		annotations map[string]string, err error)
+
+	// SetInstanceIpDNS copies DNS server/suffix/search-domain configuration
+	// onto instanceIP's backing virtual-machine-interface DHCP options and
+	// saves it, so Contrail hands the same DNS configuration out over DHCP
+	// that HNS applies locally to the endpoint's vNIC.
+	SetInstanceIpDNS(instanceIP *types.InstanceIp, dns DNSOptions) error
+
+	DeleteElementRecursive(obj contrail.IObject) error
+}
+
+// LocalNetwork is the metadata driver_core keeps for every docker network it
+// is responsible for, keyed by the docker network ID. Callers fill in
+// everything but HNSID; LocalContrailNetworkRepository.AddNetwork creates
+// the backing HNS network and fills HNSID in on success.
+type LocalNetwork struct {
+	ID              string
+	HNSID           string
+	ContrailNetUUID string
+	Tenant          string
+	Network         string
+	Subnet          string
+	Gateway         string
+	// Routes are applied as route policies on every endpoint created on this
+	// network (translated from the `com.docker.network.routes` option).
+	Routes []RouteOption
+	// NetworkMode selects which Windows HNS network driver backs this
+	// network: "transparent" (the default), "l2bridge", "l2tunnel", "nat", or
+	// "overlay". Left empty, LocalContrailNetworkRepository implementations
+	// default it to "transparent".
+	NetworkMode string
+	// VLAN tags every frame sent on this network with an 802.1Q VLAN ID.
+	// Zero leaves frames untagged.
+	VLAN uint
+	// VSID is the NVGRE Virtual Subnet ID an l2tunnel network forwards its
+	// traffic under. Zero leaves it unset.
+	VSID uint
+	// MacPools are the MAC address ranges handed out to this network's
+	// endpoints. Left empty, LocalContrailNetworkRepository implementations
+	// that need one (e.g. l2bridge) fall back to a built-in default range.
+	MacPools []MacPoolRange
+	// OutboundNAT enables source-NAT for this network's outbound traffic, if
+	// set.
+	OutboundNAT *OutboundNATOptions
+	// PoolID is the opaque handle IPAM.RequestPool returned for this
+	// network's subnet, passed back to RequestAddress/ReleaseAddress/
+	// ReleasePool for the lifetime of the network.
+	PoolID string
+}
+
+// RouteOption is a single static route to push onto every endpoint of a
+// network, as requested via the `com.docker.network.routes` option.
+type RouteOption struct {
+	Destination string
+	NextHop     string
+}
+
+// MacPoolRange is a single contiguous range of MAC addresses an HNS network
+// hands out to its endpoints (one entry of the `mac_pools` generic option's
+// list).
+type MacPoolRange struct {
+	Start string
+	End   string
+}
+
+// OutboundNATOptions enables source-NAT for a network's outbound traffic,
+// except for destinations matching Exceptions (translated from the
+// `outbound_nat_exceptions` driver-opt).
+type OutboundNATOptions struct {
+	Exceptions []string
+}
+
+// QosOptions caps an endpoint's outgoing bandwidth and sets its relative
+// scheduling priority (translated from the `qos_max_bandwidth`/
+// `qos_priority` driver-opts).
+type QosOptions struct {
+	MaxBandwidthBytes uint64
+	Priority          uint8
+}
+
+// DNSOptions is the DNS configuration handed to an endpoint's vNIC, both
+// locally (applied to the HNS/HCN endpoint) and in Contrail (handed out over
+// DHCP to match), translated from the `dns_servers`/`dns_suffix`/
+// `dns_search` driver-opts.
+type DNSOptions struct {
+	Servers []string
+	Suffix  string
+	Search  []string
+}
+
+// LocalContrailNetworkRepository tracks the mapping between docker network
+// IDs and the HNS/Contrail objects that back them.
+type LocalContrailNetworkRepository interface {
+	AddNetwork(net *LocalNetwork) error
+	GetNetwork(dockerNetID string) (*LocalNetwork, error)
+	DeleteNetwork(dockerNetID string) error
+	ListNetworks() ([]LocalNetwork, error)
+}
+
+// LocalEndpoint is the metadata driver_core keeps for every HNS endpoint it
+// creates, keyed by the docker endpoint ID. Callers fill in everything but
+// HNSID; EndpointRepository.AddEndpoint creates the backing HNS endpoint and
+// fills HNSID in on success.
+type LocalEndpoint struct {
+	ID              string
+	HNSID           string
+	DockerNetID     string
+	HNSNetworkID    string
+	ContrailVMIUUID string
+	IPAddress       string
+	// PrefixLen is the prefix length of the network's selected subnet (e.g.
+	// 24 for a /24), so consumers that report IPAddress as a CIDR (such as
+	// the CNI result) don't have to hard-code one.
+	PrefixLen  int
+	MacAddress string
+	Gateway    string
+	Routes     []RouteOption
+
+	// Host identifies the docker host this endpoint's vNIC actually lives
+	// on. It's only meaningful in GlobalScope, where a single Contrail
+	// virtual-network is shared cluster-wide (each host keeping its own
+	// epRepo) and an endpoint created on one host must still be distinguishable from
+	// endpoints created on others.
+	Host string
+
+	// FloatingIPUUID/FloatingIPAddress are set once
+	// ProgramExternalConnectivity has run for this endpoint, and cleared by
+	// RevokeExternalConnectivity.
+	FloatingIPUUID    string
+	FloatingIPAddress string
+
+	// QoS caps this endpoint's outgoing bandwidth and sets its scheduling
+	// priority, if set.
+	QoS *QosOptions
+
+	// DNS is the DNS server list, suffix and search list applied to this
+	// endpoint's vNIC, if set.
+	DNS *DNSOptions
+}
+
+// EndpointRepository tracks the mapping between docker endpoint IDs and the
+// HNS/Contrail objects that back them.
+type EndpointRepository interface {
+	AddEndpoint(ep *LocalEndpoint) error
+	GetEndpoint(dockerEndpointID string) (*LocalEndpoint, error)
+	// UpdateEndpoint persists changes to fields that are set after
+	// AddEndpoint ran, such as FloatingIPUUID/FloatingIPAddress.
+	UpdateEndpoint(ep *LocalEndpoint) error
+	DeleteEndpoint(dockerEndpointID string) error
+}
+
+// PortAssociation is the information vRouter agent needs to start forwarding
+// traffic for a container's vNIC.
+type PortAssociation struct {
+	VifUUID     string
+	InstanceID  string
+	VnID        string
+	VmProjectID string
+	IPAddress   string
+	MacAddress  string
+	SystemName  string
+}
+
+// Agent abstracts the REST API exposed by the local vRouter agent, used to
+// tell it about (and to forget) container ports.
+type Agent interface {
+	AddPort(assoc *PortAssociation) error
+	DeletePort(vifUUID string) error
+}
+
+// IPAM abstracts how the address for a new endpoint is picked and released,
+// independent of how its backing Contrail InstanceIp/VMI gets minted.
+// ContrailDriverCore calls it from CreateNetwork/CreateEndpoint instead of
+// hard-coding Contrail as the sole allocator, so a local bitmap allocator can
+// be swapped in via the "Driver.IPAMBackend" config knob.
+type IPAM interface {
+	// RequestPool registers subnetCIDR for allocation and returns an opaque
+	// pool handle to pass to RequestAddress/ReleaseAddress/ReleasePool.
+	RequestPool(tenant, network, subnetCIDR string, opts IPAMPoolOptions) (poolID string, err error)
+	ReleasePool(poolID string) error
+
+	// RequestAddress returns the address to assign a new endpoint in
+	// poolID, honouring opts.Address for static assignment.
+	RequestAddress(poolID, containerID string, opts IPAMAddressOptions) (address string, err error)
+	ReleaseAddress(poolID, address string) error
+}
+
+// IPAMPoolOptions narrows the range of addresses a pool hands out, as
+// requested via the `ip_range`/`exclude_addresses` driver-opts.
+type IPAMPoolOptions struct {
+	// IPRange restricts allocation to a sub-range of the pool's subnet,
+	// given as "<start>-<end>" (both inclusive). Left empty, the whole
+	// subnet is eligible.
+	IPRange string
+	// Exclude withholds individual addresses, or "<start>-<end>" sub-ranges,
+	// from allocation within IPRange.
+	Exclude []string
+}
+
+// IPAMAddressOptions requests a specific address from RequestAddress, for
+// static assignment, instead of letting the backend pick the next free one.
+type IPAMAddressOptions struct {
+	Address string
+}
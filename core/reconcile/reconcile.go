@@ -0,0 +1,266 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reconcile cross-references HNS networks/endpoints against
+// Contrail (and, in ModeFull, against Docker) on startup, so a driver crash
+// or upgrade doesn't leave "orphaned vifs" behind: HNS state whose Contrail
+// counterpart is gone, or Contrail/Docker state whose HNS counterpart never
+// got recreated. It mirrors what Moby's initNetworkController does for
+// Windows HNS networks on daemon startup.
+package reconcile
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/contrail_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Microsoft/hcsshim"
+	log "github.com/sirupsen/logrus"
+)
+
+// Mode selects how aggressively Reconciler.Run repairs HNS/Contrail drift.
+type Mode string
+
+const (
+	// ModeOff skips reconciliation entirely.
+	ModeOff Mode = "off"
+	// ModePrune deletes HNS networks/endpoints whose Contrail virtual-network
+	// is gone, and unbinds their vRouter agent ports.
+	ModePrune Mode = "prune"
+	// ModeFull does everything ModePrune does, and additionally recreates
+	// HNS endpoints Docker still has attached to a network whose Contrail
+	// virtual-network still exists, using Live to learn which ones.
+	ModeFull Mode = "full"
+)
+
+// ParseMode validates a "--reconcile-mode" flag value.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case ModeOff, ModePrune, ModeFull:
+		return Mode(s), nil
+	default:
+		return "", fmt.Errorf("unknown reconcile-mode %q, want one of off|prune|full", s)
+	}
+}
+
+// LiveEndpoint is the IP/MAC Docker last knew a container's endpoint to
+// have, used to recreate an HNS endpoint that didn't survive a driver
+// restart.
+type LiveEndpoint struct {
+	DockerEndpointID string
+	IPAddress        string
+	MacAddress       string
+}
+
+// LiveEndpoints is satisfied by an adapter over the Docker Engine API, and
+// is the only part of it ModeFull relies on. A nil LiveEndpoints disables
+// the recreate step even under ModeFull, degrading to ModePrune's behavior.
+type LiveEndpoints interface {
+	// EndpointsOnNetwork returns every endpoint Docker still has attached to
+	// the network backed by the Contrail virtual-network uuid.
+	EndpointsOnNetwork(uuid string) ([]LiveEndpoint, error)
+}
+
+// Summary counts the actions Run took, logged as a single structured line
+// so an operator can tell at a glance whether a startup found drift.
+type Summary struct {
+	NetworksDeleted    int
+	EndpointsDeleted   int
+	PortsUnbound       int
+	EndpointsRecreated int
+}
+
+func (s Summary) Fields() log.Fields {
+	return log.Fields{
+		"networksDeleted":    s.NetworksDeleted,
+		"endpointsDeleted":   s.EndpointsDeleted,
+		"portsUnbound":       s.PortsUnbound,
+		"endpointsRecreated": s.EndpointsRecreated,
+	}
+}
+
+// Reconciler owns the HNS/Contrail/Agent cross-reference. It only supports
+// the "hns" network backend today: the "hcn" backend needs its own
+// contrail_networking.Networking-shaped adapter before it can be wired in.
+type Reconciler struct {
+	networking contrail_networking.Networking
+	controller ports.Controller
+	agent      ports.Agent
+	live       LiveEndpoints
+}
+
+// NewReconciler returns a Reconciler for the given HNS backend. live may be
+// nil, in which case ModeFull behaves like ModePrune.
+func NewReconciler(networking contrail_networking.Networking, controller ports.Controller,
+	agent ports.Agent, live LiveEndpoints) *Reconciler {
+
+	return &Reconciler{networking: networking, controller: controller, agent: agent, live: live}
+}
+
+// Run performs one reconciliation pass and logs a structured summary of
+// every action it took. Under ModeOff it returns an empty Summary at once,
+// without logging anything.
+func (r *Reconciler) Run(mode Mode) (Summary, error) {
+	var summary Summary
+	if mode == ModeOff {
+		return summary, nil
+	}
+
+	networks, err := r.networking.ListNetworks()
+	if err != nil {
+		log.Errorln(err)
+		return summary, err
+	}
+
+	for _, netw := range networks {
+		uuid := contrailUUID(netw.Name)
+		if uuid == "" {
+			// Not one of ours (e.g. the root network InitRootHNSNetwork
+			// creates), leave it alone.
+			continue
+		}
+
+		vn, err := r.controller.GetNetworkByUUID(uuid)
+		if err != nil {
+			log.Errorln(err)
+			return summary, err
+		}
+
+		if vn == nil {
+			if err := r.pruneNetwork(netw.Id, &summary); err != nil {
+				return summary, err
+			}
+			continue
+		}
+
+		if mode == ModeFull && r.live != nil {
+			if err := r.recreateMissingEndpoints(netw.Id, uuid, &summary); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	log.WithFields(summary.Fields()).Infof("Reconciled HNS state against Contrail (mode: %s)", mode)
+	return summary, nil
+}
+
+// pruneNetwork deletes hnsNetID and every endpoint it carries, and unbinds
+// their vRouter agent ports, because its Contrail virtual-network is gone.
+func (r *Reconciler) pruneNetwork(hnsNetID string, summary *Summary) error {
+	endpoints, err := r.networking.ListEndpointsOfNetwork(hnsNetID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+
+	for _, ep := range endpoints {
+		if _, vmiUUID := parseEndpointName(ep.Name); vmiUUID == "" {
+			// HNSEndpointRepository.AddEndpoint encodes the Contrail VMI
+			// UUID agent.DeletePort needs into the endpoint's Name; an
+			// endpoint without one (e.g. created some other way) can't be
+			// positively unbound, so don't guess with the wrong ID.
+			log.Warnf("Endpoint %s has no recorded Contrail VMI UUID, skipping agent unbind", ep.Id)
+		} else if err := r.agent.DeletePort(vmiUUID); err != nil {
+			// The vRouter agent not knowing about this port isn't fatal:
+			// it's exactly the drift we're cleaning up after.
+			log.Warnf("Unbinding stale agent port %s: %s", vmiUUID, err)
+		} else {
+			summary.PortsUnbound++
+		}
+
+		if err := r.networking.DeleteEndpoint(ep.Id); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		summary.EndpointsDeleted++
+	}
+
+	if err := r.networking.DeleteNetwork(hnsNetID); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	summary.NetworksDeleted++
+	return nil
+}
+
+// recreateMissingEndpoints recreates, pinned to their original IP/MAC, any
+// HNS endpoint Docker still has attached to hnsNetID's virtual-network but
+// that's missing from HNS itself.
+func (r *Reconciler) recreateMissingEndpoints(hnsNetID, uuid string, summary *Summary) error {
+	live, err := r.live.EndpointsOnNetwork(uuid)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	if len(live) == 0 {
+		return nil
+	}
+
+	existing, err := r.networking.ListEndpointsOfNetwork(hnsNetID)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	present := make(map[string]bool, len(existing))
+	for _, ep := range existing {
+		dockerEndpointID, _ := parseEndpointName(ep.Name)
+		if dockerEndpointID != "" {
+			present[dockerEndpointID] = true
+		}
+	}
+
+	for _, ep := range live {
+		if present[ep.DockerEndpointID] {
+			continue
+		}
+
+		config := &hcsshim.HNSEndpoint{
+			VirtualNetwork: hnsNetID,
+			IPAddress:      net.ParseIP(ep.IPAddress),
+			MacAddress:     ep.MacAddress,
+		}
+		if _, err := r.networking.CreateEndpoint(config); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		summary.EndpointsRecreated++
+	}
+	return nil
+}
+
+// contrailUUID extracts the Contrail virtual-network UUID embedded in an
+// HNS network's name (e.g. "Contrail-<uuid>"), or "" if name isn't one of
+// ours.
+func contrailUUID(name string) string {
+	prefix := common.HNSNetworkPrefix + "-"
+	if len(name) <= len(prefix) || name[:len(prefix)] != prefix {
+		return ""
+	}
+	return name[len(prefix):]
+}
+
+// parseEndpointName extracts the dockerEndpointID and Contrail VMI UUID
+// hns.EndpointName encoded into an HNS endpoint's Name, or ("", "") if name
+// isn't in that form (e.g. an endpoint some other system created).
+func parseEndpointName(name string) (dockerEndpointID, vmiUUID string) {
+	parts := strings.SplitN(name, "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vrouter adapts the Hyper-V vRouter forwarding extension to the
+// ports.VRouter interface consumed by driver_core.
+package vrouter
+
+// Extension is satisfied by hyperv_extension.hyperVvRouterForwardingExtension
+// (and by its test simulator), and is the only part of that package this
+// adapter relies on.
+type Extension interface {
+	Enable() error
+	Disable() error
+	IsEnabled() (bool, error)
+	IsRunning() (bool, error)
+}
+
+// HyperVvRouter implements ports.VRouter on top of the Hyper-V forwarding
+// extension.
+type HyperVvRouter struct {
+	extension Extension
+}
+
+// NewHyperVvRouter creates a ports.VRouter backed by ext.
+func NewHyperVvRouter(ext Extension) *HyperVvRouter {
+	return &HyperVvRouter{extension: ext}
+}
+
+func (v *HyperVvRouter) Enable() error {
+	return v.extension.Enable()
+}
+
+func (v *HyperVvRouter) Disable() error {
+	return v.extension.Disable()
+}
+
+func (v *HyperVvRouter) IsEnabled() (bool, error) {
+	return v.extension.IsEnabled()
+}
+
+func (v *HyperVvRouter) IsRunning() (bool, error) {
+	return v.extension.IsRunning()
+}
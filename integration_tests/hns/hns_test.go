@@ -16,16 +16,19 @@
 package hns_integration_test
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hns"
-	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hns/win_networking"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns/win_networking"
 	"github.com/Juniper/contrail-windows-docker-driver/common"
 	"github.com/Juniper/contrail-windows-docker-driver/integration_tests/helpers"
 	"github.com/Microsoft/hcsshim"
@@ -352,6 +355,126 @@ var _ = PDescribe("HNS wrapper", func() {
 			}),
 		)
 
+		type VLANTestCase struct {
+			vlan       uint
+			shouldFail bool
+		}
+		DescribeTable("Tagging a network with a VLAN policy",
+			func(t VLANTestCase) {
+				policy, err := hns.VLANPolicy(t.vlan)
+				Expect(err).ToNot(HaveOccurred())
+
+				config := &hcsshim.HNSNetwork{
+					Name:               "vlan_test_net",
+					Type:               "transparent",
+					NetworkAdapterName: netAdapter,
+					Subnets: []hcsshim.Subnet{
+						{AddressPrefix: subnetCIDR, GatewayAddress: defaultGW},
+					},
+					Policies: []json.RawMessage{policy},
+				}
+
+				netID, err := hns.CreateHNSNetwork(config)
+				if t.shouldFail {
+					Expect(err).To(HaveOccurred())
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(netID).ToNot(Equal(""))
+
+				err = hns.DeleteHNSNetwork(netID)
+				Expect(err).ToNot(HaveOccurred())
+			},
+			Entry("VLAN 0 (untagged) works", VLANTestCase{vlan: 0}),
+			Entry("VLAN 100 works", VLANTestCase{vlan: 100}),
+			Entry("VLAN 4094 (max valid 802.1Q tag) works", VLANTestCase{vlan: 4094}),
+		)
+
+		type QosTestCase struct {
+			maxBandwidthBytes uint64
+			priority          uint8
+		}
+		DescribeTable("Creating an endpoint with a QoS policy round-trips its settings",
+			func(t QosTestCase) {
+				policy, err := hns.QOSPolicy(t.maxBandwidthBytes, t.priority)
+				Expect(err).ToNot(HaveOccurred())
+
+				epID, err := hns.CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+					VirtualNetwork: testHnsNetID,
+					Policies:       []json.RawMessage{policy},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				ep, err := hns.GetHNSEndpoint(epID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep.Policies).To(ContainElement(policy))
+
+				err = hns.DeleteHNSEndpoint(epID)
+				Expect(err).ToNot(HaveOccurred())
+			},
+			Entry("no cap, default priority", QosTestCase{maxBandwidthBytes: 0, priority: 0}),
+			Entry("10MB/s cap, default priority", QosTestCase{maxBandwidthBytes: 10 * 1024 * 1024, priority: 0}),
+			Entry("1MB/s cap, low priority", QosTestCase{maxBandwidthBytes: 1024 * 1024, priority: 1}),
+		)
+
+		Context("Reloading a network", func() {
+
+			var snapshotDir string
+
+			BeforeEach(func() {
+				var err error
+				snapshotDir, err = ioutil.TempDir("", "hns-reload-test")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(snapshotDir)
+			})
+
+			Specify("endpoints survive with the same IP and MAC, under new HNS IDs", func() {
+				const numEndpoints = 3
+				oldIDs := make([]string, numEndpoints)
+				oldIPs := make(map[string]string)
+				oldMACs := make(map[string]string)
+
+				for i := 0; i < numEndpoints; i++ {
+					epID, err := hns.CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+						VirtualNetwork: testHnsNetID,
+						IPAddress:      net.ParseIP(fmt.Sprintf("10.0.0.%d", 10+i)),
+					})
+					Expect(err).ToNot(HaveOccurred())
+
+					ep, err := hns.GetHNSEndpoint(epID)
+					Expect(err).ToNot(HaveOccurred())
+
+					oldIDs[i] = epID
+					oldIPs[epID] = ep.IPAddress.String()
+					oldMACs[epID] = ep.MacAddress
+				}
+
+				newNetID, idMapping, err := hns.ReloadNetwork(testHnsNetID, snapshotDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(newNetID).ToNot(Equal(testHnsNetID))
+				Expect(idMapping).To(HaveLen(numEndpoints))
+
+				// The old network is gone; its endpoints were recreated under
+				// the new one with the same IP/MAC.
+				testHnsNetID = newNetID
+				expectNumberOfEndpoints(numEndpoints)
+
+				for _, oldID := range oldIDs {
+					newID, ok := idMapping[oldID]
+					Expect(ok).To(BeTrue())
+
+					newEp, err := hns.GetHNSEndpoint(newID)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(newEp.VirtualNetwork).To(Equal(newNetID))
+					Expect(newEp.IPAddress.String()).To(Equal(oldIPs[oldID]))
+					Expect(newEp.MacAddress).To(Equal(oldMACs[oldID]))
+				}
+			})
+		})
+
 		Specify("Creating multiple endpoints with conflicting MACs works", func() {
 			cfg := &hcsshim.HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
@@ -374,6 +497,65 @@ var _ = PDescribe("HNS wrapper", func() {
 		})
 	})
 
+	Context("HNS network modes", func() {
+
+		type NetworkModeTestCase struct {
+			mode         string
+			needsAdapter bool
+			needsMacPool bool
+		}
+		DescribeTable("Creating a network of a given mode",
+			func(t NetworkModeTestCase) {
+				config := &hcsshim.HNSNetwork{
+					Name: "mode_test_net",
+					Type: t.mode,
+					Subnets: []hcsshim.Subnet{
+						{AddressPrefix: subnetCIDR, GatewayAddress: defaultGW},
+					},
+				}
+				if t.needsAdapter {
+					config.NetworkAdapterName = netAdapter
+				}
+				if t.needsMacPool {
+					config.MacPools = []hcsshim.MacPool{
+						{StartMacAddress: "00-15-5D-52-C0-00", EndMacAddress: "00-15-5D-52-CF-FF"},
+					}
+				}
+
+				netID, err := hns.CreateHNSNetwork(config)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(netID).ToNot(Equal(""))
+
+				net, err := hns.GetHNSNetwork(netID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(net.Type).To(Equal(t.mode))
+
+				err = hns.DeleteHNSNetwork(netID)
+				Expect(err).ToNot(HaveOccurred())
+			},
+			Entry("transparent binds to the physical adapter", NetworkModeTestCase{
+				mode:         "transparent",
+				needsAdapter: true,
+			}),
+			Entry("l2bridge binds to the physical adapter and needs a MAC pool", NetworkModeTestCase{
+				mode:         "l2bridge",
+				needsAdapter: true,
+				needsMacPool: true,
+			}),
+			Entry("l2tunnel binds to the physical adapter", NetworkModeTestCase{
+				mode:         "l2tunnel",
+				needsAdapter: true,
+			}),
+			Entry("nat is host-internal and doesn't need a physical adapter", NetworkModeTestCase{
+				mode: "nat",
+			}),
+			Entry("overlay binds to the physical adapter for its VTEP", NetworkModeTestCase{
+				mode:         "overlay",
+				needsAdapter: true,
+			}),
+		)
+	})
+
 	Context("HNS network doesn't exist", func() {
 
 		BeforeEach(func() {
@@ -529,6 +711,32 @@ var _ = PDescribe("HNS race conditions workarounds", func() {
 			}
 		})
 	})
+
+	Context("NAT networks don't require a physical adapter", func() {
+
+		configuration := &hcsshim.HNSNetwork{
+			Type: "nat",
+		}
+
+		Specify("connections don't fail creating/deleting NAT networks without NetworkAdapterName", func() {
+			for i := 0; i < numTries; i++ {
+				name := fmt.Sprintf("nat_net%v", i)
+				configuration.Name = name
+				By(fmt.Sprintf("Creating HNS network %s", name))
+				netID, err := hns.CreateHNSNetwork(configuration)
+				Expect(err).ToNot(HaveOccurred(), name)
+				conn, err := net.Dial("tcp", targetAddr)
+				Expect(err).ToNot(HaveOccurred(), name)
+				if conn != nil {
+					conn.Close()
+				}
+
+				By(fmt.Sprintf("Deleting HNS network %s", name))
+				err = hns.DeleteHNSNetwork(netID)
+				Expect(err).ToNot(HaveOccurred(), name)
+			}
+		})
+	})
 })
 
 func expectNumberOfEndpoints(num int) {
@@ -16,25 +16,38 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/cnm"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/docker_libnetwork_plugin"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/primary/docker_libnetwork_plugin/ipam"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/controller_rest/auth"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/hyperv_extension"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/contrail_ipam"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/ipam/local_ipam"
+	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hcn"
 	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/local_networking/hns"
-	"github.com/Juniper/contrail-windows-docker-driver/adapters/secondary/port_association/agent"
+	"github.com/Juniper/contrail-windows-docker-driver/agent"
 	"github.com/Juniper/contrail-windows-docker-driver/common"
+	"github.com/Juniper/contrail-windows-docker-driver/configuration"
 	"github.com/Juniper/contrail-windows-docker-driver/core/driver_core"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ipam_core"
+	"github.com/Juniper/contrail-windows-docker-driver/core/ports"
+	"github.com/Juniper/contrail-windows-docker-driver/core/reconcile"
 	"github.com/Juniper/contrail-windows-docker-driver/core/vrouter"
 	"github.com/Juniper/contrail-windows-docker-driver/logging"
+	"github.com/Juniper/contrail-windows-docker-driver/telemetry"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
+	"golang.org/x/sys/windows/svc/eventlog"
 )
 
 type WinService struct {
@@ -45,6 +58,48 @@ type WinService struct {
 	vswitchName    string
 	logDir         string
 	keys           auth.KeystoneParams
+	scope          driver_core.Scope
+	hostname       string
+	networkBackend string
+	ipamBackend    string
+	// defaultAddressPools lets the IpamDriver create a Contrail
+	// virtual-network that doesn't exist yet instead of requiring one to be
+	// pre-provisioned. Left empty (the default), every virtual-network must
+	// already exist in Contrail.
+	defaultAddressPools ipam_core.DefaultPoolsFlag
+
+	// reconcileMode controls whether Execute cross-references HNS state
+	// against Contrail before it starts serving, to garbage-collect
+	// anything left behind by a crash or upgrade. Only takes effect when
+	// networkBackend is "hns". See core/reconcile for what each mode does.
+	reconcileMode reconcile.Mode
+
+	// healthCheckInterval is how often, while Running, Execute polls the
+	// vRouter forwarding extension's IsEnabled/IsRunning state. On a failed
+	// check it logs a Windows event-log entry and attempts auto-recovery by
+	// re-enabling the extension. Zero disables the health check goroutine.
+	healthCheckInterval time.Duration
+
+	// otelEndpoint, if set, is an OTLP/HTTP collector address (e.g.
+	// "localhost:4318") every OpenTelemetry span created while serving CNM
+	// requests is exported to. Left empty (the default), tracing stays a
+	// no-op.
+	otelEndpoint string
+	// httpTrace/httpDump opt into net/http/httptrace client-trace logging,
+	// respectively full request/response dumping, on the agent HTTP client
+	// (logged at Debug level; pair with -logLevel=Debug).
+	httpTrace bool
+	httpDump  bool
+	// debugAddr, if non-empty, is the "host:port" (expected to be
+	// localhost-only) the /debug/vars and /metrics endpoints are served on.
+	// Left empty (the default), neither endpoint is served.
+	debugAddr string
+
+	// configFile, if set, is watched for changes for the lifetime of the
+	// service: Auth and Logging settings are hot-reloaded from it without
+	// restarting the service. Left empty (the default), the driver only
+	// ever uses the flags it was started with.
+	configFile string
 }
 
 func main() {
@@ -82,8 +137,57 @@ func main() {
 		"environment variable")
 	var os_token = flag.String("os_token", "", "Keystone token. If empty, will read "+
 		"environment variable")
+	var scope = flag.String("scope", "local", "Docker network scope this driver advertises: "+
+		"\"local\" (default, one host owns each network) or \"global\" (the Contrail "+
+		"virtual-network is shared cluster-wide, for use with Docker Swarm)")
+	var hostname = flag.String("hostname", "", "Identifier this host publishes into Contrail "+
+		"for endpoints it creates in \"global\" scope. Defaults to the OS hostname.")
+	var networkBackend = flag.String("networkBackend", "hns", "Windows networking API to back "+
+		"docker networks/endpoints with: \"hns\" (default, the legacy HNS HTTP-over-RPC shim) "+
+		"or \"hcn\" (the newer HCN v2 API).")
+	var ipamBackend = flag.String("ipamBackend", "contrail", "IPAM backend CreateNetwork/"+
+		"CreateEndpoint allocate addresses through: \"contrail\" (default, delegates to the "+
+		"Contrail controller as before) or \"local\" (a bitmap allocator persisted under "+
+		"ProgramData, supporting \"ip_range\"/\"exclude_addresses\" driver-opts).")
+	var configFile = flag.String("configFile", "", "Path to a config file to hot-reload Auth "+
+		"and Logging settings from while the service runs. Left empty (the default), the "+
+		"driver only ever uses the flags it was started with.")
+	var reconcileModeString = flag.String("reconcile-mode", "prune", "How to reconcile HNS "+
+		"state against Contrail before serving: \"off\" (skip it), \"prune\" (default, delete "+
+		"HNS networks/endpoints whose Contrail virtual-network is gone and unbind their "+
+		"vRouter agent ports), or \"full\" (prune, plus recreate HNS endpoints Docker still "+
+		"has attached to a network that still exists). Only takes effect when networkBackend "+
+		"is \"hns\".")
+	var healthCheckIntervalSeconds = flag.Int("healthCheckInterval", 30, "How often, in "+
+		"seconds, to poll the vRouter forwarding extension's enabled/running state while the "+
+		"service is running, auto-recovering (and logging a Windows event-log entry) if it's "+
+		"found down. 0 disables the check.")
+	var defaultAddressPools ipam_core.DefaultPoolsFlag
+	flag.Var(&defaultAddressPools, "default-address-pools", "Subnet pool the IpamDriver carves "+
+		"a CIDR from to auto-create a Contrail virtual-network that doesn't exist yet, instead "+
+		"of requiring one to be pre-provisioned: \"base=<cidr>,size=<prefixlen>\". May be "+
+		"repeated, mirroring dockerd's own --default-address-pool flag.")
+	var otelEndpoint = flag.String("otel-endpoint", "", "OTLP/HTTP collector address (e.g. "+
+		"\"localhost:4318\") to export OpenTelemetry traces of CNM requests to. Left empty "+
+		"(the default), tracing is a no-op.")
+	var httpTrace = flag.Bool("http-trace", false, "Log net/http/httptrace client-trace events "+
+		"(DNS, connection reuse, first response byte, ...) for the agent HTTP client, at Debug "+
+		"level.")
+	var httpDump = flag.Bool("http-dump", false, "Dump full request/response bodies for the "+
+		"agent HTTP client, at Debug level. Very verbose; prefer -http-trace unless a request "+
+		"needs byte-for-byte inspection.")
+	var debugAddr = flag.String("debugAddr", "", "\"host:port\" (expected to be localhost-only) "+
+		"to serve /debug/vars and /metrics on, exposing HNS retry counts, PowerShell call "+
+		"latency, and endpoint create/delete durations. Left empty (the default), neither "+
+		"endpoint is served.")
 	flag.Parse()
 
+	if *hostname == "" {
+		if h, err := os.Hostname(); err == nil {
+			*hostname = h
+		}
+	}
+
 	if *forceAsInteractive {
 		isInteractive = true
 	}
@@ -106,13 +210,31 @@ func main() {
 	}
 	keys.LoadFromEnvironment()
 
+	reconcileMode, err := reconcile.ParseMode(*reconcileModeString)
+	if err != nil {
+		log.Errorf("Invalid -reconcile-mode: %s", err)
+		return
+	}
+
 	winService := &WinService{
-		adapter:        *adapter,
-		controllerIP:   *controllerIP,
-		controllerPort: *controllerPort,
-		agentURL:       *agentURL,
-		vswitchName:    vswitchName,
-		keys:           *keys,
+		adapter:             *adapter,
+		controllerIP:        *controllerIP,
+		controllerPort:      *controllerPort,
+		agentURL:            *agentURL,
+		vswitchName:         vswitchName,
+		keys:                *keys,
+		scope:               driver_core.Scope(*scope),
+		hostname:            *hostname,
+		networkBackend:      *networkBackend,
+		ipamBackend:         *ipamBackend,
+		defaultAddressPools: defaultAddressPools,
+		reconcileMode:       reconcileMode,
+		healthCheckInterval: time.Duration(*healthCheckIntervalSeconds) * time.Second,
+		otelEndpoint:        *otelEndpoint,
+		httpTrace:           *httpTrace,
+		httpDump:            *httpDump,
+		debugAddr:           *debugAddr,
+		configFile:          *configFile,
 	}
 
 	svcRunFunc := debug.Run
@@ -130,9 +252,41 @@ func main() {
 func (ws *WinService) Execute(args []string, winChangeReqChan <-chan svc.ChangeRequest,
 	winStatusChan chan<- svc.Status) (ssec bool, errno uint32) {
 
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
 	winStatusChan <- svc.Status{State: svc.StartPending}
 
+	elog, err := eventlog.Open(common.WinServiceName)
+	if err != nil {
+		// The event source may not be registered (e.g. when running
+		// interactively via -forceAsInteractive), which isn't fatal: we
+		// just lose the Windows Event Log copy of health-check failures.
+		log.Warnf("Opening Windows event log failed, health-check failures will only be "+
+			"logged locally: %s", err)
+		elog = nil
+	} else {
+		defer elog.Close()
+	}
+
+	shutdownTracing, err := telemetry.InitTracer(ws.otelEndpoint)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Errorf("Shutting down OpenTelemetry tracing failed: %s", err)
+		}
+	}()
+
+	if ws.debugAddr != "" {
+		debugSrv, err := telemetry.ServeDebug(ws.debugAddr)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer debugSrv.Close()
+	}
+
 	hypervExtension := hyperv_extension.NewHyperVvRouterForwardingExtension(ws.vswitchName)
 	vrouter := vrouter.NewHyperVvRouter(hypervExtension)
 
@@ -148,25 +302,87 @@ func (ws *WinService) Execute(args []string, winChangeReqChan <-chan svc.ChangeR
 		return
 	}
 
-	agent := agent.NewAgentRestAPI(http.DefaultClient, agentUrl)
+	agentHTTPClient := &http.Client{Transport: telemetry.NewTransport(nil, ws.httpTrace, ws.httpDump)}
+	agent := agent.NewAgentRestAPI(agentHTTPClient, agentUrl)
+
+	hnsNetworking := hns.HNSNetworkingAdapter{}
+	var backend hns.Backend
+	switch ws.networkBackend {
+	case "hcn":
+		backend = hcn.NewHCNBackend(common.AdapterName(ws.adapter), hcn.NetworkingAdapter{})
+	default:
+		backend, err = hns.NewHNSBackend(common.AdapterName(ws.adapter), ws.vswitchName, hnsNetworking)
+	}
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	netRepo := backend.NetworkRepository()
+	epRepo := backend.EndpointRepository()
 
-	netRepo, err := hns.NewHNSContrailNetworksRepository(common.AdapterName(ws.adapter))
+	if ws.networkBackend == "hns" {
+		reconciler := reconcile.NewReconciler(hnsNetworking, controller, agent, nil)
+		if _, err := reconciler.Run(ws.reconcileMode); err != nil {
+			log.Error(err)
+			return
+		}
+	} else if ws.reconcileMode != reconcile.ModeOff {
+		log.Warnf("reconcile-mode %q is ignored: only the \"hns\" networkBackend supports "+
+			"reconciliation today", ws.reconcileMode)
+	}
 
-	epRepo := &hns.HNSEndpointRepository{}
+	var ipamBackend ports.IPAM
+	switch ws.ipamBackend {
+	case "local":
+		localIpam, err := local_ipam.NewLocalIPAM(local_ipam.DefaultDBPath())
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer localIpam.Close()
+		ipamBackend = localIpam
+	default:
+		ipamBackend = contrail_ipam.NewContrailIPAM()
+	}
 
-	core, err := driver_core.NewContrailDriverCore(vrouter, controller, agent, netRepo, epRepo)
+	core, err := driver_core.NewContrailDriverCore(vrouter, controller, agent, ipamBackend, netRepo, epRepo)
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	core = core.WithScope(ws.scope).WithHostname(ws.hostname)
+
+	// Serve our own IpamDriver alongside NetworkDriver, so networks can be
+	// created without also requiring the libnetwork "windows" null-IPAM
+	// workaround: Contrail subnets/addresses are handed out natively.
+	ipamCore := ipam_core.NewIpamCore(controller, ws.defaultAddressPools)
+	ipamSrv := ipam.NewServer(ipamCore)
+
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	if ws.configFile != "" {
+		confWatcher, err := configuration.NewWatcher(ws.configFile)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer confWatcher.Close()
+		go watchConfiguration(confWatcher, core, ipamCore, stopWatching)
+	}
 
-	d := cnm.NewServerCNM(core)
+	d := docker_libnetwork_plugin.NewDockerPluginServer(core).WithIpam(ipamSrv)
 	if err = d.StartServing(); err != nil {
 		log.Error(err)
 		return
 	}
 	defer d.StopServing()
 
+	stopHealthCheck := make(chan struct{})
+	defer close(stopHealthCheck)
+	if ws.healthCheckInterval > 0 {
+		go monitorVRouterHealth(vrouter, elog, ws.healthCheckInterval, stopHealthCheck)
+	}
+
 	winStatusChan <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 
 win_svc_loop:
@@ -179,6 +395,27 @@ win_svc_loop:
 			// Testing deadlock from https://code.google.com/p/winsvc/issues/detail?id=4
 			time.Sleep(100 * time.Millisecond)
 			winStatusChan <- svcCmd.CurrentStatus
+		case svc.Pause:
+			// Give operators the same "pause the networking stack for
+			// maintenance" experience as other Windows network services:
+			// stop serving CNM requests and disable the forwarding
+			// extension, without tearing down any state docker/Contrail
+			// would need recreated.
+			if err := d.StopServing(); err != nil {
+				log.Errorf("Pausing: stopping CNM server failed: %s", err)
+			}
+			if err := vrouter.Disable(); err != nil {
+				log.Errorf("Pausing: disabling vRouter extension failed: %s", err)
+			}
+			winStatusChan <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+		case svc.Continue:
+			if err := vrouter.Enable(); err != nil {
+				log.Errorf("Resuming: enabling vRouter extension failed: %s", err)
+			}
+			if err := d.StartServing(); err != nil {
+				log.Errorf("Resuming: restarting CNM server failed: %s", err)
+			}
+			winStatusChan <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 		case svc.Stop, svc.Shutdown:
 			break win_svc_loop
 		default:
@@ -188,3 +425,95 @@ win_svc_loop:
 	winStatusChan <- svc.Status{State: svc.StopPending}
 	return
 }
+
+// watchConfiguration applies every Configuration w publishes to core,
+// ipamCore and the process' log level, for as long as the service runs.
+// Auth is the only driver setting it rebinds: switching Auth.AuthMethod
+// tears down the current ControllerAdapter and builds a new one to match,
+// without requiring the service to restart. Both core and ipamCore are
+// rebound together, since they're handed the same Controller and otherwise
+// one of them would silently keep talking to the torn-down one.
+func watchConfiguration(w *configuration.Watcher, core *driver_core.ContrailDriverCore,
+	ipamCore *ipam_core.IpamCore, stop <-chan struct{}) {
+
+	for {
+		select {
+		case <-stop:
+			return
+		case conf, ok := <-w.Updates():
+			if !ok {
+				return
+			}
+
+			if level, err := log.ParseLevel(conf.Logging.LogLevel); err == nil {
+				log.SetLevel(level)
+			}
+
+			var newController ports.Controller
+			var err error
+			switch conf.Auth.AuthMethod {
+			case "keystone":
+				newController, err = controller_rest.NewControllerWithKeystoneAdapter(
+					&conf.Auth.Keystone, conf.Driver.ControllerIP, conf.Driver.ControllerPort)
+			default:
+				newController, err = controller_rest.NewControllerInsecureAdapter(
+					conf.Driver.ControllerIP, conf.Driver.ControllerPort)
+			}
+			if err != nil {
+				log.Errorf("rebuilding controller for reloaded configuration failed, "+
+					"keeping previous one: %v", err)
+				continue
+			}
+
+			core.SetController(newController)
+			ipamCore.SetController(newController)
+			log.Infof("rebound controller after configuration reload (AuthMethod=%s)",
+				conf.Auth.AuthMethod)
+		}
+	}
+}
+
+// monitorVRouterHealth polls vr's enabled/running state every interval until
+// stop is closed. A failed check is logged locally and, if elog is non-nil,
+// as a Windows event-log error, before monitorVRouterHealth attempts
+// auto-recovery by re-enabling vr. This closes the gap where the vRouter
+// extension silently goes down while the driver keeps serving CNM requests
+// that would then fail deep inside HNS.
+func monitorVRouterHealth(vr ports.VRouter, elog *eventlog.Log, interval time.Duration,
+	stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			enabled, err := vr.IsEnabled()
+			if err == nil && !enabled {
+				err = fmt.Errorf("vRouter forwarding extension is disabled")
+			}
+			if err == nil {
+				var running bool
+				running, err = vr.IsRunning()
+				if err == nil && !running {
+					err = fmt.Errorf("vRouter forwarding extension isn't running")
+				}
+			}
+			if err == nil {
+				continue
+			}
+
+			log.Errorf("vRouter health check failed, attempting auto-recovery: %s", err)
+			if elog != nil {
+				if logErr := elog.Error(1, err.Error()); logErr != nil {
+					log.Errorf("writing to Windows event log failed: %s", logErr)
+				}
+			}
+			if recoverErr := vr.Enable(); recoverErr != nil {
+				log.Errorf("auto-recovery failed: re-enabling vRouter extension: %s", recoverErr)
+			}
+		}
+	}
+}
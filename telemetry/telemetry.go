@@ -0,0 +1,206 @@
+//
+// Copyright (c) 2018 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telemetry centralizes the driver's opt-in diagnostics: OpenTelemetry
+// tracing (Tracer, InitTracer), an httptrace/http-dump RoundTripper for the
+// controller_rest/agent HTTP clients (NewTransport), and the counters behind
+// the /debug/vars and /metrics endpoints ServeDebug exposes, so a slow
+// CreateNetwork/CreateEndpoint request can be followed end to end instead of
+// silently absorbed in a logrus line.
+package telemetry
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this driver's spans/metrics to the otel SDK
+// and any backend it's exported to.
+const instrumentationName = "github.com/Juniper/contrail-windows-docker-driver"
+
+// Tracer returns the Tracer every package in this driver should start its
+// spans from. Until InitTracer is called with a non-empty endpoint, it's
+// backed by otel's global no-op TracerProvider, so instrumenting a function
+// with it is always safe, even with telemetry turned off.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// InitTracer points the global OpenTelemetry TracerProvider at an OTLP/HTTP
+// collector listening on otelEndpoint (e.g. "localhost:4318"), batching spans
+// from every Tracer() in the process. If otelEndpoint is empty, InitTracer
+// leaves the no-op global TracerProvider in place and returns a no-op
+// shutdown func, so tracing stays a true opt-in.
+func InitTracer(otelEndpoint string) (shutdown func(context.Context) error, err error) {
+	if otelEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(otelEndpoint),
+		otlptracehttp.WithInsecure())
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	log.Infof("Exporting OpenTelemetry traces to %s", otelEndpoint)
+	return tp.Shutdown, nil
+}
+
+// Counters backing /debug/vars and /metrics. Each is a running total rather
+// than a point-in-time gauge, so a dashboard can graph its rate of change;
+// dividing a *LatencyMillisTotal by its paired call count gives the mean
+// latency.
+var (
+	HNSNetworkCreateRetries = expvar.NewInt("hnsNetworkCreateRetries")
+
+	powerShellCalls = expvar.NewInt("powerShellCalls")
+	powerShellMs    = expvar.NewInt("powerShellLatencyMillisTotal")
+
+	endpointCreateCount = expvar.NewInt("endpointCreateCount")
+	endpointCreateMs    = expvar.NewInt("endpointCreateLatencyMillisTotal")
+	endpointDeleteCount = expvar.NewInt("endpointDeleteCount")
+	endpointDeleteMs    = expvar.NewInt("endpointDeleteLatencyMillisTotal")
+)
+
+// ObservePowerShellLatency records how long a single PowerShell invocation
+// (e.g. hyperVvRouterForwardingExtension.callOnSwitch) took.
+func ObservePowerShellLatency(d time.Duration) {
+	powerShellCalls.Add(1)
+	powerShellMs.Add(d.Milliseconds())
+}
+
+// ObserveEndpointCreateDuration records how long a single HNS endpoint
+// creation took, start to finish.
+func ObserveEndpointCreateDuration(d time.Duration) {
+	endpointCreateCount.Add(1)
+	endpointCreateMs.Add(d.Milliseconds())
+}
+
+// ObserveEndpointDeleteDuration records how long a single HNS endpoint
+// deletion took, start to finish.
+func ObserveEndpointDeleteDuration(d time.Duration) {
+	endpointDeleteCount.Add(1)
+	endpointDeleteMs.Add(d.Milliseconds())
+}
+
+// ServeDebug starts an HTTP server bound to addr (a "host:port", expected to
+// be localhost-only) exposing every process-wide expvar, including the
+// counters above, on /debug/vars, and the same counters rendered as plain
+// "name value" lines on /metrics for tools that don't speak expvar's JSON.
+// Callers own the returned server's lifetime; Close it on shutdown.
+func ServeDebug(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorln(err)
+		}
+	}()
+
+	log.Infof("Serving debug/metrics endpoints on %s", addr)
+	return srv, nil
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%s %s\n", kv.Key, kv.Value.String())
+	})
+}
+
+// NewTransport wraps base (http.DefaultTransport if nil) with opt-in
+// net/http/httptrace client-trace logging and full request/response dumping,
+// for the controller_rest and agent HTTP clients. Both trace and dump default
+// to off, so enabling neither reduces to base's own behavior.
+func NewTransport(base http.RoundTripper, traceEnabled, dumpEnabled bool) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !traceEnabled && !dumpEnabled {
+		return base
+	}
+	return &tracingTransport{base: base, trace: traceEnabled, dump: dumpEnabled}
+}
+
+type tracingTransport struct {
+	base        http.RoundTripper
+	trace, dump bool
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.trace {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace(req)))
+	}
+	if t.dump {
+		if dumped, err := httputil.DumpRequestOut(req, true); err == nil {
+			log.Debugf("http-dump request:\n%s", dumped)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	if t.dump && err == nil {
+		if dumped, err := httputil.DumpResponse(resp, true); err == nil {
+			log.Debugf("http-dump response:\n%s", dumped)
+		}
+	}
+	return resp, err
+}
+
+func clientTrace(req *http.Request) *httptrace.ClientTrace {
+	url := req.URL.String()
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			log.Debugf("http-trace %s: getting connection to %s", url, hostPort)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			log.Debugf("http-trace %s: got connection (reused: %v)", url, info.Reused)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			log.Debugf("http-trace %s: DNS resolved (err: %v)", url, info.Err)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			log.Debugf("http-trace %s: wrote request (err: %v)", url, info.Err)
+		},
+		GotFirstResponseByte: func() {
+			log.Debugf("http-trace %s: got first response byte", url)
+		},
+	}
+}